@@ -7,8 +7,6 @@ require (
 	github.com/Azure/go-autorest/autorest/adal v0.8.3 // indirect
 	github.com/alecthomas/jsonschema v0.0.0-20200530073317-71f438968921 // indirect
 	github.com/fatih/color v1.9.0
-	github.com/golang/protobuf v1.3.5 // indirect
-	github.com/google/go-cmp v0.3.1 // indirect
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/imdario/mergo v0.3.11 // indirect
 	github.com/jenkins-x/jx-api v0.0.24
@@ -17,11 +15,14 @@ require (
 	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/mattn/go-colorable v0.1.8 // indirect
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.1
+	github.com/spf13/cobra v1.0.0
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/text v0.3.5 // indirect
 	k8s.io/api v0.18.15
 	k8s.io/apimachinery v0.18.15
 	k8s.io/client-go v11.0.1-0.20190805182717-6502b5e7b1b5+incompatible
+	sigs.k8s.io/yaml v1.1.0
 )
 
 replace k8s.io/api => k8s.io/api v0.16.5
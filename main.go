@@ -2,18 +2,14 @@ package main
 
 import (
 	"github.com/jenkins-x/jx-logging/pkg/log"
-	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/cmd"
 	"github.com/jenkins-x/jx-role-controller/pkg/loghelpers"
 )
 
 func main() {
 	loghelpers.InitLogrus()
 
-	roleController, err := controller.NewRoleController()
-	if err != nil {
-		log.Logger().Fatalf(err.Error())
-	}
-	err = roleController.Run()
+	err := cmd.NewCmdRoot().Execute()
 	if err != nil {
 		log.Logger().Fatalf(err.Error())
 	}
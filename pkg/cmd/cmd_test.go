@@ -0,0 +1,66 @@
+package cmd_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jenkins-x/jx-role-controller/pkg/cmd"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/jenkins-x/jx-role-controller/pkg/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_Plan(t *testing.T) {
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: "jx",
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+	}
+	var kubeconfig, kubeContext string
+	o := &cmd.Options{
+		NewRoleController: func(config, context string) (*controller.RoleOptions, error) {
+			kubeconfig, kubeContext = config, context
+			roleController := &controller.RoleOptions{}
+			testhelpers.ConfigureTestOptionsWithResources(roleController,
+				[]runtime.Object{role},
+				[]runtime.Object{kube.NewPermanentEnvironment("staging")},
+			)
+			return roleController, nil
+		},
+	}
+	out := &bytes.Buffer{}
+	planCmd := cmd.NewCmdPlan(o)
+	planCmd.SetOut(out)
+	o.Kubeconfig = "/tmp/config"
+	o.Context = "staging-cluster"
+	planCmd.SetArgs([]string{"-o", "json"})
+
+	err := planCmd.Execute()
+	require.NoError(t, err)
+
+	assert.Equal(t, "/tmp/config", kubeconfig)
+	assert.Equal(t, "staging-cluster", kubeContext)
+	assert.Contains(t, out.String(), `"namespace": "jx-staging"`)
+	assert.Contains(t, out.String(), `"name": "myrole"`)
+}
+
+func Test_Version(t *testing.T) {
+	out := &bytes.Buffer{}
+	root := cmd.NewCmdRoot()
+	root.SetOut(out)
+	root.SetArgs([]string{"version"})
+
+	err := root.Execute()
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "version: "+version.Version)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdPlan creates the command which prints the changes a sync would make without making them
+func NewCmdPlan(o *Options) *cobra.Command {
+	format := controller.PlanFormatTable
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Prints the Role and RoleBinding changes a sync would make without making them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			roleController, err := o.roleController(cmd)
+			if err != nil {
+				return err
+			}
+			roleController.DryRun = true
+			roleController.PlanFormat = format
+			roleController.Out = cmd.OutOrStdout()
+			return roleController.Run(context.Background())
+		},
+	}
+	cmd.Flags().StringVarP(&format, "output", "o", format, "the format of the plan: table, json or yaml")
+	return cmd
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Options the flags shared by the commands which talk to the cluster
+type Options struct {
+	Kubeconfig   string
+	Context      string
+	TeamNs       string
+	LogLevel     string
+	Workers      int
+	ResyncPeriod time.Duration
+
+	ServerSideApply bool
+	FieldManager    string
+	RoleRefNoGap    bool
+	AdoptForeign    bool
+
+	// the ClusterRoles which EnvironmentRoleBindings may bind
+	AllowedClusterRoles []string
+
+	// label and annotation key prefixes copied onto the propagated objects
+	MetadataAllowPrefixes []string
+	MetadataDenyPrefixes  []string
+
+	// multi-team mode
+	TeamNamespaces []string
+	TeamSelector   string
+
+	// per resource watch options in the form resource=value
+	WatchResyncPeriods  []string
+	WatchFieldSelectors []string
+	WatchLabelSelectors []string
+
+	// NewRoleController creates the controller, it can be replaced in tests
+	NewRoleController func(kubeconfig, kubeContext string) (*controller.RoleOptions, error)
+}
+
+// NewCmdRoot creates the root command, which runs the controller when no sub command is given so existing
+// deployments keep working
+func NewCmdRoot() *cobra.Command {
+	o := &Options{
+		NewRoleController: controller.NewRoleControllerForContext,
+	}
+	cmd := &cobra.Command{
+		Use:           "jx-role-controller",
+		Short:         "Propagates the EnvironmentRoleBindings of a team into its environment namespaces",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if o.LogLevel == "" {
+				return nil
+			}
+			return errors.Wrapf(log.SetLevel(o.LogLevel), "setting log level %s", o.LogLevel)
+		},
+	}
+	run := NewCmdRun(o)
+	cmd.RunE = run.RunE
+	cmd.Flags().AddFlagSet(run.Flags())
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.Kubeconfig, "kubeconfig", "", "path to the kubeconfig file, defaults to $KUBECONFIG, ~/.kube/config or the in-cluster configuration")
+	flags.StringVar(&o.Context, "context", "", "the kubeconfig context to use, defaults to the current context")
+	flags.StringVarP(&o.TeamNs, "namespace", "n", "", "the team namespace, defaults to the namespace of the context")
+	flags.StringSliceVar(&o.TeamNamespaces, "team-namespaces", nil, "process the teams of all these namespaces rather than a single team namespace")
+	flags.StringVar(&o.TeamSelector, "team-selector", "", "process the teams of all the namespaces matching the label selector rather than a single team namespace")
+	flags.StringVar(&o.LogLevel, "log-level", "", "the log level: panic, fatal, error, warn, info, debug or trace")
+	flags.BoolVar(&o.ServerSideApply, "server-side-apply", false, "write the propagated Roles and RoleBindings with server-side apply")
+	flags.StringVar(&o.FieldManager, "field-manager", "jx-role-controller", "the field manager name used for server-side apply")
+	flags.BoolVar(&o.RoleRefNoGap, "roleref-no-gap", false, "create the RoleBinding for a changed roleRef before deleting the old one so the subjects never lose access")
+	flags.BoolVar(&o.AdoptForeign, "adopt-foreign", false, "take over the Roles and RoleBindings in the environment namespaces which were not created by the controller")
+	flags.StringSliceVar(&o.AllowedClusterRoles, "allowed-cluster-role", nil, "the ClusterRoles which EnvironmentRoleBindings may bind, * allows any")
+	flags.StringSliceVar(&o.MetadataAllowPrefixes, "metadata-allow-prefix", nil, "copy the labels and annotations with these key prefixes from the team Roles and EnvironmentRoleBindings")
+	flags.StringSliceVar(&o.MetadataDenyPrefixes, "metadata-deny-prefix", nil, "never copy the labels and annotations with these key prefixes")
+	flags.StringArrayVar(&o.WatchFieldSelectors, "watch-field-selector", nil, "only process the resources matching the field selector, e.g. environments=metadata.name!=dev")
+	flags.StringArrayVar(&o.WatchLabelSelectors, "watch-label-selector", nil, "only process the resources matching the label selector, e.g. roles=jenkins.io/kind=EnvironmentRole")
+
+	cmd.AddCommand(run, NewCmdSync(o), NewCmdPlan(o), NewCmdVersion())
+	return cmd
+}
+
+// roleController creates the controller configured by the environment variables then overrides it with any flags
+// which were explicitly set
+func (o *Options) roleController(cmd *cobra.Command) (*controller.RoleOptions, error) {
+	roleController, err := o.NewRoleController(o.Kubeconfig, o.Context)
+	if err != nil {
+		return nil, err
+	}
+	if o.TeamNs != "" {
+		roleController.TeamNs = o.TeamNs
+	}
+	if cmd.Flags().Changed("team-namespaces") {
+		roleController.TeamNamespaces = o.TeamNamespaces
+	}
+	if cmd.Flags().Changed("team-selector") {
+		roleController.TeamSelector = o.TeamSelector
+	}
+	if cmd.Flags().Changed("workers") {
+		roleController.Workers = o.Workers
+	}
+	if cmd.Flags().Changed("resync-period") {
+		roleController.ResyncPeriod = o.ResyncPeriod
+	}
+	if cmd.Flags().Changed("server-side-apply") {
+		roleController.ServerSideApply = o.ServerSideApply
+	}
+	if cmd.Flags().Changed("roleref-no-gap") {
+		roleController.RoleRefNoGap = o.RoleRefNoGap
+	}
+	if cmd.Flags().Changed("adopt-foreign") {
+		roleController.AdoptForeign = o.AdoptForeign
+	}
+	if cmd.Flags().Changed("allowed-cluster-role") {
+		roleController.AllowedClusterRoles = o.AllowedClusterRoles
+	}
+	if cmd.Flags().Changed("metadata-allow-prefix") {
+		roleController.Metadata.AllowPrefixes = o.MetadataAllowPrefixes
+	}
+	if cmd.Flags().Changed("metadata-deny-prefix") {
+		roleController.Metadata.DenyPrefixes = o.MetadataDenyPrefixes
+	}
+	if cmd.Flags().Changed("field-manager") {
+		roleController.FieldManager = o.FieldManager
+	}
+	err = o.applyWatchFlags(roleController)
+	if err != nil {
+		return nil, err
+	}
+	return roleController, nil
+}
+
+// applyWatchFlags overrides the watch options of the controller with the per resource flags
+func (o *Options) applyWatchFlags(roleController *controller.RoleOptions) error {
+	update := func(values []string, fn func(watch *controller.WatchOptions, value string) error) error {
+		for _, text := range values {
+			parts := strings.SplitN(text, "=", 2)
+			if len(parts) != 2 {
+				return errors.Errorf("invalid watch option %q, expecting resource=value where resource is one of %s", text, strings.Join(controller.WatchedResources, ", "))
+			}
+			if roleController.Watches == nil {
+				roleController.Watches = map[string]controller.WatchOptions{}
+			}
+			watch := roleController.Watches[parts[0]]
+			err := fn(&watch, parts[1])
+			if err != nil {
+				return errors.Wrapf(err, "parsing watch option %q", text)
+			}
+			roleController.Watches[parts[0]] = watch
+		}
+		return nil
+	}
+	return util.CombineErrors(
+		update(o.WatchResyncPeriods, func(watch *controller.WatchOptions, value string) error {
+			period, err := time.ParseDuration(value)
+			watch.ResyncPeriod = period
+			return err
+		}),
+		update(o.WatchFieldSelectors, func(watch *controller.WatchOptions, value string) error {
+			watch.FieldSelector = value
+			return nil
+		}),
+		update(o.WatchLabelSelectors, func(watch *controller.WatchOptions, value string) error {
+			watch.LabelSelector = value
+			return nil
+		}),
+	)
+}
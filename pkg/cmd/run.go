@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/jenkins-x/jx-role-controller/pkg/signals"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdRun creates the command which runs the controller until it receives SIGINT or SIGTERM
+func NewCmdRun(o *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Runs the controller, reconciling changes until it is stopped",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			roleController, err := o.roleController(cmd)
+			if err != nil {
+				return err
+			}
+			return roleController.Run(signals.NewContext())
+		},
+	}
+	cmd.Flags().IntVar(&o.Workers, "workers", 1, "the number of workers reconciling changes concurrently")
+	cmd.Flags().DurationVar(&o.ResyncPeriod, "resync-period", 0, "how often the watches replay every resource, defaults to 10m")
+	cmd.Flags().StringArrayVar(&o.WatchResyncPeriods, "watch-resync-period", nil, "the resync period of one resource, e.g. environments=1h")
+	return cmd
+}
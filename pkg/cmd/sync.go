@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCmdSync creates the command which reconciles the team once then exits
+func NewCmdSync(o *Options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync",
+		Short: "Reconciles the Roles and RoleBindings of the team once then exits",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			roleController, err := o.roleController(cmd)
+			if err != nil {
+				return err
+			}
+			roleController.NoWatch = true
+			roleController.DryRun = false
+			return roleController.Run(context.Background())
+		},
+	}
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jenkins-x/jx-role-controller/pkg/version"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdVersion creates the command which prints the version of the binary
+func NewCmdVersion() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Prints the version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintf(cmd.OutOrStdout(), "version: %s\ncommit: %s\nbuild date: %s\n", version.Version, version.Commit, version.BuildDate)
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// aggregatedClusterRoles returns the names of the ClusterRoles whose rules are added to the propagated copies of
+// the Role
+func aggregatedClusterRoles(role *rbacv1.Role) []string {
+	var answer []string
+	for _, name := range strings.Split(role.Annotations[kube.AnnotationAggregateClusterRoles], ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && util.StringArrayIndex(answer, name) < 0 {
+			answer = append(answer, name)
+		}
+	}
+	return answer
+}
+
+// propagatedRules returns the rules of the Role followed by the rules of any aggregated ClusterRoles which are not
+// already included. ClusterRoles which are missing or not one of the allowed cluster roles are reported as events
+// against the Role and contribute no rules, as are the rules for non-resource URLs which only a ClusterRole can grant
+func (o *RoleOptions) propagatedRules(role *rbacv1.Role) ([]rbacv1.PolicyRule, error) {
+	names := aggregatedClusterRoles(role)
+	if len(names) == 0 {
+		return role.Rules, nil
+	}
+	answer := append([]rbacv1.PolicyRule{}, role.Rules...)
+	for _, name := range names {
+		// aggregating copies the rules of the ClusterRole into every environment, which grants as much as binding it
+		if !o.clusterRoleAllowed(name) {
+			log.Logger().Warnf("Not aggregating cluster role %s into role %s as it is not one of the allowed cluster roles", name, role.Name)
+			o.event(role, corev1.EventTypeWarning, reasonClusterRoleNotAllowed, "ClusterRole %s is not allowed so its rules are not aggregated into the Role", name)
+			continue
+		}
+		clusterRole, err := o.getClusterRole(name)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				log.Logger().Warnf("Cannot find cluster role %s aggregated into role %s", name, role.Name)
+				o.event(role, corev1.EventTypeWarning, reasonClusterRoleNotFound, "ClusterRole %s aggregated into the Role was not found", name)
+				continue
+			}
+			return nil, errors.Wrapf(err, "getting cluster role %s aggregated into role %s", name, role.Name)
+		}
+		nonResourceRules := 0
+		for _, rule := range clusterRole.Rules {
+			if len(rule.NonResourceURLs) > 0 {
+				nonResourceRules++
+				continue
+			}
+			if !containsRule(answer, rule) {
+				answer = append(answer, rule)
+			}
+		}
+		if nonResourceRules > 0 {
+			log.Logger().Warnf("Ignoring %d non-resource URL rules of cluster role %s aggregated into role %s", nonResourceRules, name, role.Name)
+			o.event(role, corev1.EventTypeWarning, reasonNonResourceRulesIgnored, "Ignored %d non-resource URL rule(s) of ClusterRole %s as a Role cannot grant them",
+				nonResourceRules, name)
+		}
+	}
+	return answer, nil
+}
+
+func containsRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) bool {
+	for _, r := range rules {
+		if reflect.DeepEqual(r, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClusterRole returns the ClusterRole from the watch if we are watching them, otherwise from the API server
+func (o *RoleOptions) getClusterRole(name string) (*rbacv1.ClusterRole, error) {
+	if store := o.stores[clusterroles]; store != nil {
+		obj, exists, err := store.GetByKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, apierrors.NewNotFound(rbacv1.Resource(clusterroles), name)
+		}
+		return obj.(*rbacv1.ClusterRole), nil
+	}
+	return o.KubeClient.RbacV1().ClusterRoles().Get(name, metav1.GetOptions{})
+}
+
+// onClusterRole propagates the team Roles which aggregate the ClusterRole and the EnvironmentRoleBindings which
+// refer to it again, as its rules or existence changed
+func (o *RoleOptions) onClusterRole(name string) error {
+	var errorMap []error
+	for _, role := range o.listRoles() {
+		if kube.IsEnvironmentRole(role.Labels) && util.StringArrayIndex(aggregatedClusterRoles(role), name) >= 0 {
+			errorMap = append(errorMap, o.UpsertRole(role))
+		}
+	}
+	for _, binding := range o.listEnvironmentRoleBindings() {
+		if binding.Spec.RoleRef.Kind == kindClusterRole && binding.Spec.RoleRef.Name == name {
+			errorMap = append(errorMap, o.UpsertEnvironmentRoleBinding(binding))
+		}
+	}
+	return util.CombineErrors(errorMap...)
+}
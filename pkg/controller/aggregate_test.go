@@ -0,0 +1,242 @@
+package controller_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_AggregateClusterRoles(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:             true,
+		Recorder:            recorder,
+		AllowedClusterRoles: []string{"edit", "missing"},
+	}
+	teamNs := "jx"
+	teamRule := rbacv1.PolicyRule{
+		Verbs:     []string{"get", "watch", "list"},
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+	}
+	editRule := rbacv1.PolicyRule{
+		Verbs:     []string{"create", "update", "delete"},
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments"},
+	}
+	nonResourceRule := rbacv1.PolicyRule{
+		Verbs:           []string{"get"},
+		NonResourceURLs: []string{"/healthz"},
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+			Annotations: map[string]string{
+				kube.AnnotationAggregateClusterRoles: "edit, missing,edit,cluster-admin",
+			},
+		},
+		Rules: []rbacv1.PolicyRule{teamRule},
+	}
+	editRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "edit",
+		},
+		// the rule already in the team Role is not added twice and a Role cannot grant non-resource URLs
+		Rules: []rbacv1.PolicyRule{teamRule, editRule, nonResourceRule},
+	}
+
+	// not one of the allowed cluster roles so its rules must not be aggregated
+	adminRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster-admin",
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"*"},
+				APIGroups: []string{"*"},
+				Resources: []string{"*"},
+			},
+		},
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{role, editRole, adminRole},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	r, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{teamRule, editRule}, r.Rules, "rules of the propagated Role")
+
+	r, err = o.KubeClient.RbacV1().Roles(teamNs).Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{teamRule}, r.Rules, "the team Role should not be modified")
+
+	// changing the rules of the ClusterRole changes the propagated Role
+	editRule.Verbs = []string{"*"}
+	editRole.Rules = []rbacv1.PolicyRule{editRule}
+	_, err = o.KubeClient.RbacV1().ClusterRoles().Update(editRole)
+	require.NoError(t, err)
+
+	err = o.Run(context.Background())
+	require.NoError(t, err)
+
+	r, err = o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{teamRule, editRule}, r.Rules, "rules of the propagated Role after the ClusterRole changed")
+
+	assertEvents(t, drainEvents(recorder),
+		"Warning ClusterRoleNotFound ClusterRole missing aggregated into the Role was not found",
+		"Warning ClusterRoleNotAllowed ClusterRole cluster-admin is not allowed so its rules are not aggregated into the Role",
+		"Warning NonResourceRulesIgnored Ignored 1 non-resource URL rule(s) of ClusterRole edit as a Role cannot grant them",
+		"Normal RoleUpdated Updated Role myrole in namespace jx-staging",
+	)
+}
+
+func Test_WatchClusterRoles(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		WatchClusterRoles:   true,
+		AllowedClusterRoles: []string{"view", "edit"},
+		ShutdownTimeout:     10 * time.Second,
+	}
+	teamNs := "jx"
+	teamRule := rbacv1.PolicyRule{
+		Verbs:     []string{"get", "watch", "list"},
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+	}
+	editRule := rbacv1.PolicyRule{
+		Verbs:     []string{"create", "update", "delete"},
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments"},
+	}
+	editRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "edit",
+		},
+		Rules: []rbacv1.PolicyRule{editRule},
+	}
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+					Annotations: map[string]string{
+						kube.AnnotationAggregateClusterRoles: "edit",
+					},
+				},
+				Rules: []rbacv1.PolicyRule{teamRule},
+			},
+			editRole,
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			// the ClusterRole does not exist yet so it cannot be bound
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "viewers",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "ClusterRole",
+						Name:     "view",
+					},
+				},
+			},
+		},
+	)
+	kubeClient := o.KubeClient.(*fake.Clientset)
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the controller should become ready")
+	waitForWatch(t, &kubeClient.Fake, "clusterroles")
+
+	r, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{teamRule, editRule}, r.Rules, "rules of the propagated Role")
+
+	// changing the rules of the aggregated ClusterRole propagates the Role again
+	changedRule := editRule
+	changedRule.Verbs = []string{"*"}
+	editRole.Rules = []rbacv1.PolicyRule{changedRule}
+	_, err = o.KubeClient.RbacV1().ClusterRoles().Update(editRole)
+	require.NoError(t, err)
+	waitFor(t, func() bool {
+		r, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+		return err == nil && reflect.DeepEqual([]rbacv1.PolicyRule{teamRule, changedRule}, r.Rules)
+	}, "the propagated Role should get the changed rules of the ClusterRole")
+
+	// creating the bound ClusterRole propagates the EnvironmentRoleBinding again
+	_, err = o.KubeClient.RbacV1().ClusterRoles().Create(&rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "view",
+		},
+	})
+	require.NoError(t, err)
+	waitFor(t, func() bool {
+		_, err := o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("viewers", metav1.GetOptions{})
+		return err == nil
+	}, "the RoleBinding should be created once the ClusterRole exists")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
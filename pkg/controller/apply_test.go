@@ -0,0 +1,212 @@
+package controller_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	rbacclient "k8s.io/client-go/kubernetes/typed/rbac/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// applyClient serves reads from the fake clientset and sends the server-side apply requests, which the fake
+// clientset does not support, to a test server
+type applyClient struct {
+	kubernetes.Interface
+	rbac rbacclient.RbacV1Interface
+}
+
+func (c *applyClient) RbacV1() rbacclient.RbacV1Interface {
+	return c.rbac
+}
+
+type applyRbacClient struct {
+	rbacclient.RbacV1Interface
+	restClient rest.Interface
+}
+
+func (c *applyRbacClient) RESTClient() rest.Interface {
+	return c.restClient
+}
+
+type applyRequest struct {
+	path         string
+	contentType  string
+	fieldManager string
+	body         string
+}
+
+func Test_ServerSideApply(t *testing.T) {
+	t.Parallel()
+	requests, _, err := runServerSideApply(t, http.StatusOK, `{}`)
+	require.NoError(t, err)
+
+	paths := map[string]applyRequest{}
+	for _, r := range requests {
+		paths[r.path] = r
+	}
+	for _, path := range []string{
+		"/apis/rbac.authorization.k8s.io/v1/namespaces/jx-staging/roles/myrole",
+		"/apis/rbac.authorization.k8s.io/v1/namespaces/jx-staging/rolebindings/mybinding",
+	} {
+		r, ok := paths[path]
+		if assert.True(t, ok, "expected an apply request for %s", path) {
+			assert.Equal(t, "application/apply-patch+yaml", r.contentType)
+			assert.Equal(t, "jx-role-controller", r.fieldManager)
+			assert.NotContains(t, r.body, "creationTimestamp")
+			assert.Contains(t, r.body, `"jenkins.io/created-by":"jx"`)
+		}
+	}
+	assert.Contains(t, paths["/apis/rbac.authorization.k8s.io/v1/namespaces/jx-staging/roles/myrole"].body, `"rules":[{"apiGroups":[""],"resources":["configmaps"],"verbs":["get"]}]`)
+	assert.Contains(t, paths["/apis/rbac.authorization.k8s.io/v1/namespaces/jx-staging/rolebindings/mybinding"].body, `"roleRef":{"apiGroup":"rbac.authorization.k8s.io","kind":"Role","name":"myrole"}`)
+}
+
+func Test_ServerSideApplyUnchanged(t *testing.T) {
+	t.Parallel()
+	owned := metav1.ObjectMeta{
+		Namespace:       "jx-staging",
+		ResourceVersion: "42",
+		Labels: map[string]string{
+			kube.LabelCreatedBy: kube.ValueCreatedByJX,
+			kube.LabelTeam:      "jx",
+		},
+	}
+	role := &rbacv1.Role{ObjectMeta: owned}
+	role.Name = "myrole"
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: owned,
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     "myrole",
+		},
+	}
+	binding.Name = "mybinding"
+	applied := metrics.Writes.WithLabelValues("Role", "apply", "jx-staging")
+	appliedBefore := testutil.ToFloat64(applied)
+
+	// the server answers with the resourceVersion the objects already had, as it does when an apply changes nothing
+	requests, events, err := runServerSideApply(t, http.StatusOK, `{"metadata":{"resourceVersion":"42"}}`, role, binding)
+	require.NoError(t, err)
+	assert.NotEmpty(t, requests, "the Role and RoleBinding should still be applied")
+	for _, event := range events {
+		assert.NotContains(t, event, "Applied", "no event should be recorded for an apply which changed nothing")
+	}
+	assert.Equal(t, float64(0), testutil.ToFloat64(applied)-appliedBefore, "an apply which changed nothing should not count as a write")
+}
+
+func Test_ServerSideApplyConflict(t *testing.T) {
+	t.Parallel()
+	_, _, err := runServerSideApply(t, http.StatusConflict, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Conflict","code":409,"message":"Apply failed with 1 conflict: conflict with \"kubectl\": .rules"}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "applying Role myrole in namespace jx-staging")
+	assert.Contains(t, err.Error(), "also managed by another field manager")
+}
+
+// runServerSideApply runs the controller against a server answering each apply with the status and response,
+// returning the apply requests and the events recorded
+func runServerSideApply(t *testing.T, status int, response string, existing ...runtime.Object) ([]applyRequest, []string, error) {
+	var lock sync.Mutex
+	var requests []applyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		requests = append(requests, applyRequest{
+			path:         r.URL.Path,
+			contentType:  r.Header.Get("Content-Type"),
+			fieldManager: r.URL.Query().Get("fieldManager"),
+			body:         string(body),
+		})
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:         true,
+		ServerSideApply: true,
+		Recorder:        recorder,
+	}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		append([]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+				Rules: []rbacv1.PolicyRule{
+					{
+						Verbs:     []string{"get"},
+						APIGroups: []string{""},
+						Resources: []string{"configmaps"},
+					},
+				},
+			},
+		}, existing...),
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+					Environments: []v1.EnvironmentFilter{
+						{
+							Includes: []string{"staging"},
+						},
+					},
+				},
+			},
+		},
+	)
+	restClient, err := rbacclient.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	o.KubeClient = &applyClient{
+		Interface: o.KubeClient,
+		rbac: &applyRbacClient{
+			RbacV1Interface: o.KubeClient.RbacV1(),
+			restClient:      restClient.RESTClient(),
+		},
+	}
+
+	err = o.Run(context.Background())
+	lock.Lock()
+	defer lock.Unlock()
+	return requests, drainEvents(recorder), err
+}
@@ -0,0 +1,291 @@
+package controller
+
+import (
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// environmentNamespaces returns the distinct namespaces of the team environments
+func (o *RoleOptions) environmentNamespaces() ([]string, error) {
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing environments")
+	}
+	var answer []string
+	for idx := range envList.Items {
+		ns := envList.Items[idx].Spec.Namespace
+		if ns != "" && util.StringArrayIndex(answer, ns) < 0 {
+			answer = append(answer, ns)
+		}
+	}
+	return answer, nil
+}
+
+// deleteOwnedRoleBinding deletes the RoleBinding in the namespace if it was created by the controller for this team,
+// recording an event against the source object if it is not nil
+func (o *RoleOptions) deleteOwnedRoleBinding(ns, name string, source runtime.Object) error {
+	roleBinding, err := o.KubeClient.RbacV1().RoleBindings(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "getting RoleBinding %s in namespace %s", name, ns)
+	}
+	if !kube.IsOwnedByTeam(roleBinding.Labels, o.TeamNs) {
+		log.Logger().Infof("not deleting RoleBinding %s in namespace %s as it is not owned by team %s", name, ns, o.TeamNs)
+		return nil
+	}
+	log.Logger().Infof("Deleting RoleBinding %s in namespace %s", name, ns)
+	err = o.deleteRoleBinding(ns, name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	o.writeEvent(kindRoleBinding, operationDelete, ns, name, err, source)
+	if err != nil {
+		return errors.Wrapf(err, "deleting RoleBinding %s in namespace %s", name, ns)
+	}
+	return nil
+}
+
+// removeEnvironmentRoleBindingFromEnvironments deletes the RoleBindings propagated from the EnvironmentRoleBinding
+// of the given name from all the environment namespaces
+func (o *RoleOptions) removeEnvironmentRoleBindingFromEnvironments(name string, source runtime.Object) error {
+	log.Logger().Infof("removing environment role binding %s from environments", name)
+	namespaces, err := o.environmentNamespaces()
+	if err != nil {
+		return err
+	}
+	var errorMap []error
+	for _, ns := range namespaces {
+		errorMap = append(errorMap, o.deleteOwnedRoleBinding(ns, name, source))
+	}
+	return util.CombineErrors(errorMap...)
+}
+
+// ensureFinalizer adds the controller finalizer to the EnvironmentRoleBinding so that we get a chance to clean up
+// the propagated RoleBindings even if it is deleted while the controller is not running.
+// The finalizer blocks the deletion of the namespace of the binding until the controller removes it, which never
+// happens if the controller runs in that namespace and is deleted along with it. So the bindings in the
+// ControllerNamespace are left without the finalizer, removing it if an earlier version added it
+func (o *RoleOptions) ensureFinalizer(binding *v1.EnvironmentRoleBinding) (*v1.EnvironmentRoleBinding, error) {
+	idx := util.StringArrayIndex(binding.Finalizers, kube.FinalizerRoleController)
+	if o.ControllerNamespace != "" && binding.Namespace == o.ControllerNamespace {
+		if idx < 0 {
+			return binding, nil
+		}
+		updated := binding.DeepCopy()
+		updated.Finalizers = append(updated.Finalizers[:idx], updated.Finalizers[idx+1:]...)
+		updated, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(binding.Namespace).Update(updated)
+		if err != nil {
+			return binding, errors.Wrapf(err, "removing finalizer from environment role binding %s", binding.Name)
+		}
+		return updated, nil
+	}
+	if idx >= 0 {
+		return binding, nil
+	}
+	updated := binding.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers, kube.FinalizerRoleController)
+	updated, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(binding.Namespace).Update(updated)
+	if err != nil {
+		return binding, errors.Wrapf(err, "adding finalizer to environment role binding %s", binding.Name)
+	}
+	return updated, nil
+}
+
+// DeleteEnvironmentRoleBinding processes the deletion of an EnvironmentRoleBinding, removing the propagated
+// RoleBindings from every environment namespace before releasing the finalizer
+// its public so that we can make testing easier
+func (o *RoleOptions) DeleteEnvironmentRoleBinding(binding *v1.EnvironmentRoleBinding) error {
+	o.initStores()
+	o.forgetEnvironmentRoleBinding(binding.Name)
+	o.forgetStatus(binding.Name)
+
+	err := o.removeEnvironmentRoleBindingFromEnvironments(binding.Name, binding)
+	if err != nil {
+		return err
+	}
+
+	if o.DryRun {
+		return nil
+	}
+	return o.removeFinalizer(binding)
+}
+
+// removeFinalizer removes the controller finalizer from the EnvironmentRoleBinding if it has it
+func (o *RoleOptions) removeFinalizer(binding *v1.EnvironmentRoleBinding) error {
+	idx := util.StringArrayIndex(binding.Finalizers, kube.FinalizerRoleController)
+	if idx < 0 {
+		return nil
+	}
+	updated := binding.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers[:idx], updated.Finalizers[idx+1:]...)
+	_, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(binding.Namespace).Update(updated)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "removing finalizer from environment role binding %s", binding.Name)
+	}
+	return nil
+}
+
+// releaseFinalizers removes the controller finalizer from all the EnvironmentRoleBindings of the team once the
+// controller stops processing it, otherwise nothing would be left to release them and their deletion would hang
+func (o *RoleOptions) releaseFinalizers() error {
+	bindingList, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(o.TeamNs).List(o.listOptions(environmentrolebindings))
+	if err != nil {
+		return errors.Wrap(err, "listing environment role bindings")
+	}
+	var errorMap []error
+	for idx := range bindingList.Items {
+		errorMap = append(errorMap, o.removeFinalizer(&bindingList.Items[idx]))
+	}
+	return util.CombineErrors(errorMap...)
+}
+
+// deleteOwnedRole deletes the Role in the namespace if it was created by the controller for this team, recording an
+// event against the source object if it is not nil
+func (o *RoleOptions) deleteOwnedRole(ns, name string, source runtime.Object) error {
+	role, err := o.KubeClient.RbacV1().Roles(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "getting Role %s in namespace %s", name, ns)
+	}
+	if !kube.IsOwnedByTeam(role.Labels, o.TeamNs) {
+		log.Logger().Infof("not deleting Role %s in namespace %s as it is not owned by team %s", name, ns, o.TeamNs)
+		return nil
+	}
+	log.Logger().Infof("Deleting Role %s in namespace %s", name, ns)
+	err = o.deleteRole(ns, name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	o.writeEvent(kindRole, operationDelete, ns, name, err, source)
+	if err != nil {
+		return errors.Wrapf(err, "deleting Role %s in namespace %s", name, ns)
+	}
+	return nil
+}
+
+// removeRoleFromEnvironments deletes the copies of the team Role of the given name from the environment namespaces.
+// If keepBound is true then copies still referenced by an EnvironmentRoleBinding matching the environment are kept.
+// Events are recorded against the source object if it is not nil
+func (o *RoleOptions) removeRoleFromEnvironments(name string, keepBound bool, source runtime.Object) error {
+	log.Logger().Infof("removing role %s from environments", name)
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
+	if err != nil {
+		return errors.Wrap(err, "listing environments")
+	}
+	var namespaces, boundNamespaces []string
+	for idx := range envList.Items {
+		env := &envList.Items[idx]
+		ns := env.Spec.Namespace
+		if ns == "" || ns == o.TeamNs {
+			continue
+		}
+		if keepBound && o.roleBoundInEnvironment(name, env) {
+			boundNamespaces = append(boundNamespaces, ns)
+		}
+		if util.StringArrayIndex(namespaces, ns) < 0 {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	var errorMap []error
+	for _, ns := range namespaces {
+		if util.StringArrayIndex(boundNamespaces, ns) < 0 {
+			errorMap = append(errorMap, o.deleteOwnedRole(ns, name, source))
+		}
+	}
+	return util.CombineErrors(errorMap...)
+}
+
+// roleBoundInEnvironment returns true if an EnvironmentRoleBinding matching the environment references the Role
+func (o *RoleOptions) roleBoundInEnvironment(name string, env *v1.Environment) bool {
+	for _, binding := range o.listEnvironmentRoleBindings() {
+		if binding.Spec.RoleRef.Kind != kindClusterRole && binding.Spec.RoleRef.Name == name && kube.BindingMatchesEnvironment(env, binding) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEnvironmentRoles deletes the Roles copied into the namespace of the removed or moved environment, unless it
+// is the team namespace or another environment of the team still uses the namespace
+func (o *RoleOptions) removeEnvironmentRoles(env *v1.Environment) error {
+	ns := env.Spec.Namespace
+	if ns == "" || ns == o.TeamNs || o.namespaceInUse(ns, env.Name) {
+		return nil
+	}
+	log.Logger().Infof("removing the roles of environment %s from namespace %s", env.Name, ns)
+	roleList, err := o.KubeClient.RbacV1().Roles(ns).List(metav1.ListOptions{LabelSelector: o.ownedSelector()})
+	if err != nil {
+		return errors.Wrapf(err, "listing roles in namespace %s", ns)
+	}
+	var errorMap []error
+	for idx := range roleList.Items {
+		errorMap = append(errorMap, o.deleteOwnedRole(ns, roleList.Items[idx].Name, env))
+	}
+	return util.CombineErrors(errorMap...)
+}
+
+// namespaceInUse returns true if a reconciled environment other than the named one uses the namespace
+func (o *RoleOptions) namespaceInUse(ns, exceptEnvironment string) bool {
+	o.environmentsLock.RLock()
+	defer o.environmentsLock.RUnlock()
+	for name, env := range o.environments {
+		if name != exceptEnvironment && env.Spec.Namespace == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceBoundByOtherEnvironment returns true if an environment other than the named one uses the namespace and is
+// matched by the EnvironmentRoleBinding, so its RoleBinding there is still wanted. The environments are listed rather
+// than taken from the reconciled ones, which are incomplete during a sync and before the watch has been processed
+func (o *RoleOptions) namespaceBoundByOtherEnvironment(ns, exceptEnvironment string, binding *v1.EnvironmentRoleBinding) (bool, error) {
+	envs, err := o.listEnvironments()
+	if err != nil {
+		return false, err
+	}
+	for _, env := range envs {
+		if env.Name != exceptEnvironment && env.Spec.Namespace == ns && kube.BindingMatchesEnvironment(env, binding) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listEnvironments returns the team environments, from the watch store when we are watching them
+func (o *RoleOptions) listEnvironments() ([]*v1.Environment, error) {
+	if store := o.stores[environments]; store != nil {
+		var answer []*v1.Environment
+		for _, obj := range store.List() {
+			answer = append(answer, obj.(*v1.Environment))
+		}
+		return answer, nil
+	}
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing environments")
+	}
+	answer := make([]*v1.Environment, 0, len(envList.Items))
+	for idx := range envList.Items {
+		answer = append(answer, &envList.Items[idx])
+	}
+	return answer, nil
+}
+
+// DeleteRole processes the deletion of a team Role, removing the copies of it from every environment namespace
+// this function is public for easier testing
+func (o *RoleOptions) DeleteRole(name string) error {
+	o.initStores()
+	o.forgetRole(name)
+	return o.removeRoleFromEnvironments(name, false, nil)
+}
@@ -0,0 +1,230 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_DeleteEnvironmentRoleBinding(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	roleName := "myrole"
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      roleName,
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"get", "watch", "list"},
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "pods", "services"},
+			},
+		},
+	}
+	envRoleBinding := &v1.EnvironmentRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       roleName,
+			Namespace:  teamNs,
+			Finalizers: []string{kube.FinalizerRoleController},
+		},
+		Spec: v1.EnvironmentRoleBindingSpec{
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      "jenkins",
+					Namespace: teamNs,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     roleName,
+			},
+		},
+	}
+	// a hand made RoleBinding with the same name which the controller must leave alone
+	foreignRoleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      roleName,
+			Namespace: "jx-production",
+		},
+		RoleRef: envRoleBinding.Spec.RoleRef,
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			role,
+			foreignRoleBinding,
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			kube.NewPermanentEnvironment("production"),
+			envRoleBinding,
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	ownedNamespaces := []string{teamNs, "jx-staging"}
+	for _, ns := range ownedNamespaces {
+		_, err = o.KubeClient.RbacV1().RoleBindings(ns).Get(roleName, metav1.GetOptions{})
+		require.NoError(t, err, "Failed to find RoleBinding in namespace %s for name %s", ns, roleName)
+	}
+
+	// now lets simulate the deletion of the binding being blocked by our finalizer
+	envRoleBinding, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get(roleName, metav1.GetOptions{})
+	require.NoError(t, err)
+	now := metav1.NewTime(time.Now())
+	envRoleBinding.DeletionTimestamp = &now
+	envRoleBinding, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Update(envRoleBinding)
+	require.NoError(t, err)
+
+	err = o.UpsertEnvironmentRoleBinding(envRoleBinding)
+	require.NoError(t, err)
+
+	for _, ns := range ownedNamespaces {
+		_, err = o.KubeClient.RbacV1().RoleBindings(ns).Get(roleName, metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "RoleBinding %s should have been removed from namespace %s", roleName, ns)
+	}
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-production").Get(roleName, metav1.GetOptions{})
+	assert.NoError(t, err, "RoleBinding %s not created by the controller should not be removed", roleName)
+
+	envRoleBinding, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get(roleName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, envRoleBinding.Finalizers, kube.FinalizerRoleController, "finalizer should have been removed")
+}
+
+func Test_NoFinalizerInControllerNamespace(t *testing.T) {
+	t.Parallel()
+	o := newHealthOptions(time.Minute)
+	o.ControllerNamespace = "jx"
+	// added by an earlier version of the controller
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings("jx").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	binding.Finalizers = []string{kube.FinalizerRoleController}
+	_, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings("jx").Update(binding)
+	require.NoError(t, err)
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the controller should become ready")
+
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.NoError(t, err, "the binding should still be propagated")
+	binding, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings("jx").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, binding.Finalizers, kube.FinalizerRoleController,
+		"a binding in the namespace of the controller should not block the deletion of the namespace")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
+
+func Test_DeleteRole(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	rules := []rbacv1.PolicyRule{
+		{
+			Verbs:     []string{"get", "watch", "list"},
+			APIGroups: []string{""},
+			Resources: []string{"configmaps", "pods", "services"},
+		},
+	}
+	deletedRole := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deleted",
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+		Rules: rules,
+	}
+	unlabelledRole := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unlabelled",
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+		Rules: rules,
+	}
+	// a hand made Role with the same name which the controller must leave alone
+	foreignRole := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deleted",
+			Namespace: "jx-production",
+		},
+		Rules: rules,
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			deletedRole,
+			unlabelledRole,
+			foreignRole,
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			kube.NewPermanentEnvironment("production"),
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	for _, name := range []string{"deleted", "unlabelled"} {
+		_, err = o.KubeClient.RbacV1().Roles("jx-staging").Get(name, metav1.GetOptions{})
+		require.NoError(t, err, "Failed to find Role in namespace jx-staging for name %s", name)
+	}
+
+	err = o.DeleteRole("deleted")
+	require.NoError(t, err)
+
+	_, err = o.KubeClient.RbacV1().Roles("jx-staging").Get("deleted", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "Role deleted should have been removed from namespace jx-staging")
+	_, err = o.KubeClient.RbacV1().Roles("jx-production").Get("deleted", metav1.GetOptions{})
+	assert.NoError(t, err, "Role deleted not created by the controller should not be removed")
+
+	// now lets remove the kind label
+	unlabelledRole, err = o.KubeClient.RbacV1().Roles(teamNs).Get("unlabelled", metav1.GetOptions{})
+	require.NoError(t, err)
+	delete(unlabelledRole.Labels, kube.LabelKind)
+	unlabelledRole, err = o.KubeClient.RbacV1().Roles(teamNs).Update(unlabelledRole)
+	require.NoError(t, err)
+
+	err = o.UpsertRole(unlabelledRole)
+	require.NoError(t, err)
+
+	for _, ns := range []string{"jx-staging", "jx-production"} {
+		_, err = o.KubeClient.RbacV1().Roles(ns).Get("unlabelled", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "Role unlabelled should have been removed from namespace %s", ns)
+	}
+	_, err = o.KubeClient.RbacV1().Roles(teamNs).Get("unlabelled", metav1.GetOptions{})
+	assert.NoError(t, err, "the source Role should not be removed")
+}
@@ -0,0 +1,202 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_ClusterRoleRef(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:             true,
+		Recorder:            recorder,
+		AllowedClusterRoles: []string{"view", "missing"},
+	}
+	teamNs := "jx"
+	newBinding := func(name, kind, roleName string) *v1.EnvironmentRoleBinding {
+		return &v1.EnvironmentRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+			},
+			Spec: v1.EnvironmentRoleBindingSpec{
+				Subjects: []rbacv1.Subject{
+					{
+						Kind:      "ServiceAccount",
+						Name:      "jenkins",
+						Namespace: teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     kind,
+					Name:     roleName,
+				},
+				Environments: []v1.EnvironmentFilter{
+					{
+						Includes: []string{"staging"},
+					},
+				},
+			},
+		}
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "view",
+				},
+			},
+			&rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster-admin",
+				},
+			},
+			// bound before cluster-admin was removed from the allowed cluster roles
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "admins",
+					Namespace: "jx-staging",
+					Labels: map[string]string{
+						kube.LabelCreatedBy: kube.ValueCreatedByJX,
+						kube.LabelTeam:      teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "ClusterRole",
+					Name:     "cluster-admin",
+				},
+			},
+			// a team Role of the same name must not be copied for a ClusterRole reference
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "view",
+					Namespace: teamNs,
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			newBinding("viewers", "ClusterRole", "view"),
+			newBinding("missing", "ClusterRole", "missing"),
+			newBinding("invalid", "Group", "view"),
+			newBinding("admins", "ClusterRole", "cluster-admin"),
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	roleBinding, err := o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("viewers", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "ClusterRole", roleBinding.RoleRef.Kind)
+	assert.Equal(t, "view", roleBinding.RoleRef.Name)
+	_, err = o.KubeClient.RbacV1().Roles("jx-staging").Get("view", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "Role view should not be copied for a ClusterRole reference")
+
+	for _, name := range []string{"missing", "invalid", "admins"} {
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get(name, metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "RoleBinding %s should not be created", name)
+
+		binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get(name, metav1.GetOptions{})
+		require.NoError(t, err)
+		status, err := controller.GetSyncStatus(binding)
+		require.NoError(t, err)
+		if assert.NotNil(t, status, "status of %s", name) && assert.Len(t, status.Namespaces, 1) {
+			assert.False(t, status.Namespaces[0].InSync(), "status of %s", name)
+		}
+	}
+	assertEvents(t, drainEvents(recorder),
+		"Warning ClusterRoleNotFound ClusterRole missing not found so it cannot be bound in namespace jx-staging",
+		"Warning InvalidRoleRef Cannot propagate to namespace jx-staging: roleRef kind \"Group\" is not Role or ClusterRole",
+		"Warning ClusterRoleNotAllowed ClusterRole cluster-admin is not allowed so it cannot be bound in namespace jx-staging",
+		"Normal RoleBindingDeleted Deleted RoleBinding admins in namespace jx-staging",
+	)
+}
+
+// Test_ClusterRoleRefNotAllowedByDefault shows that upgrading without setting AllowedClusterRoles revokes the
+// RoleBindings of ClusterRoles created by earlier versions, both when syncing and when pruning
+func Test_ClusterRoleRefNotAllowedByDefault(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	existing := func() *rbacv1.RoleBinding {
+		return &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "viewers",
+				Namespace: "jx-staging",
+				Labels: map[string]string{
+					kube.LabelCreatedBy: kube.ValueCreatedByJX,
+					kube.LabelTeam:      teamNs,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     "view",
+			},
+		}
+	}
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "view",
+				},
+			},
+			existing(),
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "viewers",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "ClusterRole",
+						Name:     "view",
+					},
+				},
+			},
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("viewers", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the sync should revoke the ClusterRole as no ClusterRoles are allowed")
+
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Create(existing())
+	require.NoError(t, err)
+	err = o.Prune()
+	require.NoError(t, err)
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("viewers", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the prune should revoke the ClusterRole as no ClusterRoles are allowed")
+}
@@ -0,0 +1,114 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Test_ConcurrentEvents processes roles and environment role bindings from many goroutines at once while full syncs
+// are running so that `go test -race` can detect unsynchronized access to the controller state
+func Test_ConcurrentEvents(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	count := 10
+
+	var k8sObjects, jxObjects []runtime.Object
+	var roles []*rbacv1.Role
+	var bindings []*v1.EnvironmentRoleBinding
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("role-%d", i)
+		role := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+				Labels: map[string]string{
+					kube.LabelKind: kube.ValueKindEnvironmentRole,
+				},
+			},
+			Rules: []rbacv1.PolicyRule{
+				{
+					Verbs:     []string{"get", "watch", "list"},
+					APIGroups: []string{""},
+					Resources: []string{"configmaps"},
+				},
+			},
+		}
+		binding := &v1.EnvironmentRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+			},
+			Spec: v1.EnvironmentRoleBindingSpec{
+				Subjects: []rbacv1.Subject{
+					{
+						Kind:      "ServiceAccount",
+						Name:      "jenkins",
+						Namespace: teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "Role",
+					Name:     name,
+				},
+			},
+		}
+		roles = append(roles, role)
+		bindings = append(bindings, binding)
+		k8sObjects = append(k8sObjects, role)
+		jxObjects = append(jxObjects, binding)
+	}
+	jxObjects = append(jxObjects, kube.NewPermanentEnvironment("staging"), kube.NewPermanentEnvironment("production"))
+
+	testhelpers.ConfigureTestOptionsWithResources(o, k8sObjects, jxObjects)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		role := roles[i]
+		binding := bindings[i]
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			_ = o.UpsertRole(role)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = o.UpsertEnvironmentRoleBinding(binding)
+		}()
+		go func() {
+			defer wg.Done()
+			// concurrent creates of the same objects may conflict so we only care about data races here
+			_ = o.Run(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	for _, ns := range []string{"jx-staging", "jx-production"} {
+		for _, role := range roles {
+			r, err := o.KubeClient.RbacV1().Roles(ns).Get(role.Name, metav1.GetOptions{})
+			if assert.NoError(t, err, "Failed to find Role in namespace %s for name %s", ns, role.Name) {
+				assert.Equal(t, role.Rules, r.Rules, "Role.Rules for name %s in namespace %s", role.Name, ns)
+			}
+			_, err = o.KubeClient.RbacV1().RoleBindings(ns).Get(role.Name, metav1.GetOptions{})
+			assert.NoError(t, err, "Failed to find RoleBinding in namespace %s for name %s", ns, role.Name)
+		}
+	}
+}
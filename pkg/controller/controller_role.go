@@ -1,16 +1,24 @@
 package controller
 
 import (
+	"context"
+	"io"
+	"net/http"
 	"os"
 	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
 	"github.com/jenkins-x/jx-role-controller/pkg/util"
 	"github.com/pkg/errors"
 
@@ -18,12 +26,14 @@ import (
 
 	"github.com/jenkins-x/jx-logging/pkg/log"
 	"k8s.io/client-go/kubernetes"
-	kv1 "k8s.io/client-go/kubernetes/typed/rbac/v1"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
 
 	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
 	"github.com/jenkins-x/jx-api/pkg/client/clientset/versioned"
-	"github.com/jenkins-x/jx-kube-client/pkg/kubeclient"
+	jxlisters "github.com/jenkins-x/jx-api/pkg/client/listers/jenkins.io/v1"
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -34,28 +44,149 @@ type RoleOptions struct {
 	kubeConfig *rest.Config
 	NoWatch    bool
 	TeamNs     string
-
-	Roles           map[string]*rbacv1.Role
-	EnvRoleBindings map[string]*v1.EnvironmentRoleBinding
+	Workers    int
+
+	// DryRun computes the changes a single sync would make without touching the cluster, see Changes and WritePlan
+	DryRun bool
+	// PlanFormat is the format the dry-run plan is printed in: table, json or yaml
+	PlanFormat string
+	// Out is where the dry-run plan is printed, defaults to stdout
+	Out io.Writer
+
+	// ResyncPeriod is how often the watches replay every resource so that missed changes are reconciled
+	ResyncPeriod time.Duration
+
+	// Watches restricts and tunes the watch of each resource, keyed by resource: roles, environments or
+	// environmentrolebindings
+	Watches map[string]WatchOptions
+
+	// ServerSideApply writes the propagated Roles and RoleBindings with server-side apply so the controller only
+	// owns the fields it manages
+	ServerSideApply bool
+	// FieldManager is the field manager name used for server-side apply
+	FieldManager string
+
+	// WatchClusterRoles watches the ClusterRoles so that changes to the ones aggregated into EnvironmentRoles or
+	// referenced by EnvironmentRoleBindings are propagated straight away. This needs the permission to list and watch
+	// ClusterRoles, without which the watch never syncs, so it is off by default and the changes are picked up on the
+	// next resync instead. The chart turns it on whenever it grants the permission
+	WatchClusterRoles bool
+
+	// AllowedClusterRoles are the names of the ClusterRoles which EnvironmentRoleBindings may bind, "*" allows any.
+	// Bindings of any other ClusterRole are refused so that teams cannot grant themselves cluster wide roles
+	AllowedClusterRoles []string
+
+	// RoleRefNoGap creates a RoleBinding for the new role before deleting the old RoleBinding when the immutable
+	// roleRef of an EnvironmentRoleBinding changes, so the subjects never lose access while it is recreated
+	RoleRefNoGap bool
+
+	// TeamNamespaces are the namespaces of the teams processed in multi-team mode, instead of TeamNs
+	TeamNamespaces []string
+	// TeamSelector is a label selector on namespaces which discovers the teams processed in multi-team mode
+	TeamSelector string
+	// TeamDiscoveryInterval is how often the teams are discovered again in multi-team mode
+	TeamDiscoveryInterval time.Duration
+
+	// AdoptForeign takes over the Roles and RoleBindings in the environment namespaces which were not created by the
+	// controller but have the name of ones it propagates, labelling them as owned by the team. By default they are
+	// left alone and reported
+	AdoptForeign bool
+
+	// Metadata selects the labels and annotations copied from the team Roles and EnvironmentRoleBindings
+	Metadata MetadataOptions
+
+	// Recorder records the events of the controller, defaults to recording them in the team namespace
+	Recorder record.EventRecorder
+
+	// PruneInterval is how often orphaned Roles and RoleBindings are pruned while watching, with NoWatch they are
+	// pruned once after the sync. Zero disables pruning
+	PruneInterval time.Duration
+
+	// ControllerNamespace is the namespace the controller runs in. Its EnvironmentRoleBindings are not given the
+	// finalizer: deleting the namespace stops the controller too, so nothing would be left to remove the finalizer and
+	// the deletion of the namespace would hang. Their RoleBindings are removed by the next prune instead
+	ControllerNamespace string
+
+	// MetricsAddr is the address to serve the prometheus metrics on, empty disables the metrics endpoint
+	MetricsAddr string
+	// ShutdownTimeout is how long to wait for in-flight reconciles to finish when stopping
+	ShutdownTimeout time.Duration
+
+	// HealthAddr is the address to serve the /healthz and /readyz endpoints on, empty disables them
+	HealthAddr string
+	// LivenessTimeout is how long the watches may keep failing to list or watch, or a reconcile may run, before
+	// /healthz fails, zero disables the checks
+	LivenessTimeout time.Duration
+
+	// LeaderElection configures running more than one replica with only the leader reconciling
+	LeaderElection LeaderElectionOptions
+
+	// Roles lists the team Roles, from the watch when watching and otherwise the ones synced so far
+	Roles rbaclisters.RoleLister
+	// EnvRoleBindings lists the team EnvironmentRoleBindings, from the watch when watching and otherwise the ones
+	// synced so far
+	EnvRoleBindings jxlisters.EnvironmentRoleBindingLister
+
+	queue            workqueue.RateLimitingInterface
+	stores           map[string]cache.Store
+	storesOnce       sync.Once
+	roleIndexer      cache.Indexer
+	bindingIndexer   cache.Indexer
+	environments     map[string]*v1.Environment
+	environmentsLock sync.RWMutex
+	informers        []cache.Controller
+	initial          initialPass
+	health           health
+	recorderOnce     sync.Once
+	syncStatus       map[string]map[string]NamespaceSyncStatus
+	statusLock       sync.Mutex
+	plan             plan
 }
 
 const (
 	blankSting = ""
 	// expecting values: "true" || "yes"
-	watchEnvVar             = "JX_CONTROLLER_NO_WATCH"
-	roles                   = "roles"
-	environments            = "environments"
-	environmentrolebindings = "environmentrolebindings"
+	watchEnvVar               = "JX_CONTROLLER_NO_WATCH"
+	dryRunEnvVar              = "JX_CONTROLLER_DRY_RUN"
+	planFormatEnvVar          = "JX_CONTROLLER_PLAN_FORMAT"
+	workersEnvVar             = "JX_CONTROLLER_WORKERS"
+	pruneIntervalEnvVar       = "JX_CONTROLLER_PRUNE_INTERVAL"
+	resyncPeriodEnvVar        = "JX_CONTROLLER_RESYNC_PERIOD"
+	serverSideApplyEnvVar     = "JX_CONTROLLER_SERVER_SIDE_APPLY"
+	fieldManagerEnvVar        = "JX_CONTROLLER_FIELD_MANAGER"
+	roleRefNoGapEnvVar        = "JX_CONTROLLER_ROLEREF_NO_GAP"
+	watchClusterRolesEnvVar   = "JX_CONTROLLER_WATCH_CLUSTER_ROLES"
+	adoptForeignEnvVar        = "JX_CONTROLLER_ADOPT_FOREIGN"
+	allowedClusterRolesEnvVar = "JX_CONTROLLER_ALLOWED_CLUSTER_ROLES"
+	allowAnyClusterRole       = "*"
+	defaultFieldManager       = "jx-role-controller"
+	roleRefBridgeSuffix       = "-roleref-bridge"
+	metricsAddrEnvVar         = "JX_CONTROLLER_METRICS_ADDR"
+	healthAddrEnvVar          = "JX_CONTROLLER_HEALTH_ADDR"
+	shutdownTimeoutEnvVar     = "JX_CONTROLLER_SHUTDOWN_TIMEOUT"
+	livenessTimeoutEnvVar     = "JX_CONTROLLER_LIVENESS_TIMEOUT"
+	controllerNamespaceEnvVar = "JX_CONTROLLER_NAMESPACE"
+	defaultWorkers            = 1
+	defaultPruneInterval      = time.Minute * 30
+	defaultResyncPeriod       = time.Minute * 10
+	defaultMetricsAddr        = ":8080"
+	defaultHealthAddr         = ":8081"
+	defaultShutdownTimeout    = time.Second * 30
+	defaultLivenessTimeout    = time.Minute * 15
+	roles                     = "roles"
+	environments              = "environments"
+	environmentrolebindings   = "environmentrolebindings"
+	clusterroles              = "clusterroles"
 )
 
 func NewRoleController() (*RoleOptions, error) {
+	return NewRoleControllerForContext("", "")
+}
 
-	namespace, err := kubeclient.CurrentNamespace()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	kubeClient, kubeConfig, err := kube.NewClientAndConfig()
+// NewRoleControllerForContext creates the controller for the context in the kubeconfig file, an empty kubeconfig and
+// context use the in-cluster or default configuration
+func NewRoleControllerForContext(kubeconfig, kubeContext string) (*RoleOptions, error) {
+	kubeClient, kubeConfig, namespace, err := kube.NewClientAndConfigForContext(kubeconfig, kubeContext)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -66,28 +197,228 @@ func NewRoleController() (*RoleOptions, error) {
 	}
 
 	roleController := &RoleOptions{
-		JxClient:   JxClient,
-		KubeClient: kubeClient,
-		kubeConfig: kubeConfig,
-		TeamNs:     namespace,
+		JxClient:              JxClient,
+		KubeClient:            kubeClient,
+		kubeConfig:            kubeConfig,
+		TeamNs:                namespace,
+		ResyncPeriod:          defaultResyncPeriod,
+		FieldManager:          defaultFieldManager,
+		TeamDiscoveryInterval: defaultTeamDiscoveryInterval,
+		PruneInterval:         defaultPruneInterval,
+		MetricsAddr:           defaultMetricsAddr,
+		HealthAddr:            defaultHealthAddr,
+		ShutdownTimeout:       defaultShutdownTimeout,
+		LivenessTimeout:       defaultLivenessTimeout,
+		LeaderElection:        DefaultLeaderElectionOptions(),
 	}
 
 	if os.Getenv(watchEnvVar) != "" {
 		roleController.NoWatch = util.EnvVarBoolean(os.Getenv(watchEnvVar))
 	}
+	if os.Getenv(dryRunEnvVar) != "" {
+		roleController.DryRun = util.EnvVarBoolean(os.Getenv(dryRunEnvVar))
+	}
+	if os.Getenv(serverSideApplyEnvVar) != "" {
+		roleController.ServerSideApply = util.EnvVarBoolean(os.Getenv(serverSideApplyEnvVar))
+	}
+	if os.Getenv(watchClusterRolesEnvVar) != "" {
+		roleController.WatchClusterRoles = util.EnvVarBoolean(os.Getenv(watchClusterRolesEnvVar))
+	}
+	if os.Getenv(adoptForeignEnvVar) != "" {
+		roleController.AdoptForeign = util.EnvVarBoolean(os.Getenv(adoptForeignEnvVar))
+	}
+	if value, ok := os.LookupEnv(allowedClusterRolesEnvVar); ok {
+		roleController.AllowedClusterRoles = splitList(value)
+	}
+	if os.Getenv(roleRefNoGapEnvVar) != "" {
+		roleController.RoleRefNoGap = util.EnvVarBoolean(os.Getenv(roleRefNoGapEnvVar))
+	}
+	if os.Getenv(fieldManagerEnvVar) != "" {
+		roleController.FieldManager = os.Getenv(fieldManagerEnvVar)
+	}
+	if value, ok := os.LookupEnv(planFormatEnvVar); ok {
+		roleController.PlanFormat = value
+	}
+	if os.Getenv(workersEnvVar) != "" {
+		roleController.Workers, err = strconv.Atoi(os.Getenv(workersEnvVar))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", workersEnvVar)
+		}
+	}
+	if value, ok := os.LookupEnv(metricsAddrEnvVar); ok {
+		roleController.MetricsAddr = value
+	}
+	if value, ok := os.LookupEnv(healthAddrEnvVar); ok {
+		roleController.HealthAddr = value
+	}
+	if value, ok := os.LookupEnv(controllerNamespaceEnvVar); ok {
+		roleController.ControllerNamespace = value
+	}
+	err = durationEnvVar(resyncPeriodEnvVar, &roleController.ResyncPeriod)
+	if err != nil {
+		return nil, err
+	}
+	err = durationEnvVar(pruneIntervalEnvVar, &roleController.PruneInterval)
+	if err != nil {
+		return nil, err
+	}
+	err = durationEnvVar(shutdownTimeoutEnvVar, &roleController.ShutdownTimeout)
+	if err != nil {
+		return nil, err
+	}
+	err = durationEnvVar(livenessTimeoutEnvVar, &roleController.LivenessTimeout)
+	if err != nil {
+		return nil, err
+	}
+	err = roleController.loadWatchEnv()
+	if err != nil {
+		return nil, err
+	}
+	roleController.Metadata.loadEnv()
+	err = roleController.loadTeamsEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = roleController.LeaderElection.loadEnv()
+	if err != nil {
+		return nil, err
+	}
 
 	return roleController, nil
 }
 
-func (o *RoleOptions) Run() error {
+// durationEnvVar parses the environment variable into the duration if it is set
+func durationEnvVar(name string, value *time.Duration) error {
+	text := os.Getenv(name)
+	if text == "" {
+		return nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", name)
+	}
+	*value = duration
+	return nil
+}
 
+// Run synchronises the team and, unless NoWatch is set, keeps reconciling changes until the context is cancelled.
+// With NoWatch it prunes once after the sync if PruneInterval is set.
+// In DryRun mode it only prints the plan of the changes a single sync and prune would make.
+// In multi-team mode it does the same for every discovered team
+func (o *RoleOptions) Run(ctx context.Context) error {
+	err := util.CombineErrors(o.validateWatches(), o.validateTeams())
+	if err != nil {
+		return err
+	}
+	o.initStores()
+	if o.DryRun {
+		return o.runDryRun()
+	}
 	if !o.NoWatch {
-		o.watchRoles()
-		o.watchEnvironmentRoleBindings()
-		o.watchEnvironments()
+		servers := []*http.Server{o.serveMetrics(), o.serveHealth()}
+		defer o.shutdownServers(servers)
+	}
+	if !o.NoWatch && o.LeaderElection.Enabled {
+		return o.runWithLeaderElection(ctx)
+	}
+	return o.run(ctx)
+}
+
+func (o *RoleOptions) run(ctx context.Context) error {
+	if o.MultiTeam() {
+		return o.runTeams(ctx)
+	}
+	if o.NoWatch {
+		return o.syncAndPrune()
+	}
+	stop := ctx.Done()
+	o.health.setLivenessTimeout(o.LivenessTimeout)
+	o.queue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "jx-role-controller")
+	o.watchRoles(stop)
+	o.watchEnvironmentRoleBindings(stop)
+	o.watchEnvironments(stop)
+	if o.WatchClusterRoles {
+		o.watchClusterRoles(stop)
+	}
+	o.listFromWatches()
+
+	var synced []cache.InformerSynced
+	for _, informer := range o.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(stop, synced...) {
+		return errors.New("timed out waiting for the watches to sync")
+	}
+	// the initial list of the watches has enqueued every resource, so rather than a full sync which would give up at
+	// the first failure we let the workers reconcile them with retries and become ready once they all have been
+	o.startInitialPass()
+
+	var wg sync.WaitGroup
+	o.runWorkers(stop, &wg)
+	if o.PruneInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(o.prunePeriodically, o.PruneInterval, stop)
+		}()
+	}
+
+	<-stop
+	log.Logger().Infof("stopping, waiting up to %s for in-flight reconciles to finish", o.ShutdownTimeout)
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		log.Logger().Info("stopped")
+		return nil
+	case <-time.After(o.ShutdownTimeout):
+		return errors.Errorf("timed out after %s waiting for in-flight reconciles to finish", o.ShutdownTimeout)
 	}
+}
 
-	var roles, err = o.KubeClient.RbacV1().Roles(o.TeamNs).List(metav1.ListOptions{})
+func (o *RoleOptions) runDryRun() error {
+	var err error
+	if o.MultiTeam() {
+		o.plan.reset()
+		err = o.dryRunTeams()
+	} else {
+		err = o.dryRun()
+	}
+	if err != nil {
+		return err
+	}
+	out := o.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	return o.WritePlan(out, o.PlanFormat)
+}
+
+// dryRun computes the plan of the changes a single sync and prune would make
+func (o *RoleOptions) dryRun() error {
+	o.plan.reset()
+	return o.syncAndPrune()
+}
+
+// syncAndPrune performs a single sync followed by a prune if pruning is enabled, which is what NoWatch runs and what
+// the dry run plans
+func (o *RoleOptions) syncAndPrune() error {
+	err := o.sync()
+	if err != nil {
+		return err
+	}
+	if o.PruneInterval > 0 {
+		return o.Prune()
+	}
+	return nil
+}
+
+// sync performs a full reconciliation of all the roles, environment role bindings and environments in the team namespace
+func (o *RoleOptions) sync() error {
+	var roles, err = o.KubeClient.RbacV1().Roles(o.TeamNs).List(o.listOptions(roles))
 	if err != nil {
 		return err
 	}
@@ -97,7 +428,7 @@ func (o *RoleOptions) Run() error {
 			return errors.Wrap(err, "upserting role")
 		}
 	}
-	bindings, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(o.TeamNs).List(metav1.ListOptions{})
+	bindings, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(o.TeamNs).List(o.listOptions(environmentrolebindings))
 	if err != nil {
 		return err
 	}
@@ -107,114 +438,94 @@ func (o *RoleOptions) Run() error {
 			return errors.Wrap(err, "upsert environment role binding resource")
 		}
 	}
-	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(metav1.ListOptions{})
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
 	if err != nil {
 		return err
 	}
 	for idx := 0; idx < len(envList.Items); idx++ {
-		err = o.upsertEnvironment(&envList.Items[idx])
+		env := &envList.Items[idx]
+		err = o.upsertEnvironment(env)
 		if err != nil {
 			return err
 		}
+		o.storeReconciledEnvironment(env.Name, env)
 	}
 	o.upsertRoleIntoEnvRole()
+	metrics.RecordFullSync(o.TeamNs)
 	return nil
 }
 
-func (o *RoleOptions) watcher(resource string, obj runtime.Object, wait bool, addFunc, deleteFunc func(obj interface{}), updateFunc func(oldObj, newObj interface{})) {
-	client := o.JxClient.JenkinsV1().RESTClient()
-	if resource == roles {
-		client = o.KubeClient.RbacV1().RESTClient()
-	}
+func (o *RoleOptions) watcher(resource string, obj runtime.Object, stop <-chan struct{}) {
 	log.Logger().Infof("starting watcher for %s resource", resource)
-	listWatch := cache.NewListWatchFromClient(client, resource, o.TeamNs, fields.Everything())
+	listWatch := o.listWatch(resource)
+	o.health.trackListWatch(resource, listWatch)
 	kube.SortListWatchByName(listWatch)
-	_, controller := cache.NewInformer(
+	store, controller := cache.NewIndexerInformer(
 		listWatch,
 		obj,
-		time.Minute*10,
+		o.resyncPeriod(resource),
 		cache.ResourceEventHandlerFuncs{
-			AddFunc:    addFunc,
-			UpdateFunc: updateFunc,
-			DeleteFunc: deleteFunc,
+			AddFunc: func(obj interface{}) {
+				o.enqueue(resource, obj)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if statusOnlyUpdate(oldObj, newObj) {
+					return
+				}
+				o.enqueue(resource, newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				o.enqueue(resource, obj)
+			},
 		},
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 	)
+	if o.stores == nil {
+		o.stores = map[string]cache.Store{}
+	}
+	o.stores[resource] = store
 
-	stop := make(chan struct{})
+	o.informers = append(o.informers, controller)
 
 	log.Logger().Infof("starting controller for %s watcher", resource)
 	go controller.Run(stop)
+}
 
-	if wait {
-		// Wait forever
-		select {}
-	}
+func (o *RoleOptions) watchRoles(stop <-chan struct{}) {
+	o.watcher(roles, &rbacv1.Role{}, stop)
 }
 
-func (o *RoleOptions) watchRoles() {
-	role := &rbacv1.Role{}
-	o.watcher(roles, role, false,
-		func(obj interface{}) {
-			o.onRole(nil, obj)
-		},
-		func(obj interface{}) {
-			o.onRole(obj, nil)
-		},
-		func(oldObj, newObj interface{}) {
-			o.onRole(oldObj, newObj)
-		},
-	)
+func (o *RoleOptions) watchEnvironmentRoleBindings(stop <-chan struct{}) {
+	o.watcher(environmentrolebindings, &v1.EnvironmentRoleBinding{}, stop)
 }
 
-func (o *RoleOptions) watchEnvironmentRoleBindings() {
-	environmentRoleBinding := &v1.EnvironmentRoleBinding{}
-	o.watcher(environmentrolebindings, environmentRoleBinding, false,
-		func(obj interface{}) {
-			o.onEnvironmentRoleBinding(nil, obj)
-		},
-		func(obj interface{}) {
-			o.onEnvironmentRoleBinding(obj, nil)
-		},
-		func(oldObj, newObj interface{}) {
-			o.onEnvironmentRoleBinding(oldObj, newObj)
-		},
-	)
+func (o *RoleOptions) watchEnvironments(stop <-chan struct{}) {
+	o.watcher(environments, &v1.Environment{}, stop)
 }
 
-func (o *RoleOptions) watchEnvironments() {
-	environment := &v1.Environment{}
-	o.watcher(environments, environment, true,
-		func(obj interface{}) {
-			o.onEnvironment(nil, obj)
-		},
-		func(obj interface{}) {
-			o.onEnvironment(obj, nil)
-		},
-		func(oldObj, newObj interface{}) {
-			o.onEnvironment(oldObj, newObj)
-		},
-	)
+func (o *RoleOptions) watchClusterRoles(stop <-chan struct{}) {
+	o.watcher(clusterroles, &rbacv1.ClusterRole{}, stop)
 }
 
-func (o *RoleOptions) onEnvironment(oldObj, newObj interface{}) {
-	var newEnv *v1.Environment
-	if newObj != nil {
-		newEnv = newObj.(*v1.Environment)
-	}
-	if oldObj != nil {
-		oldEnv := oldObj.(*v1.Environment)
-		if oldEnv != nil {
-			if newEnv == nil || newEnv.Spec.Namespace != oldEnv.Spec.Namespace {
-				o.removeEnvironmentRoleBinding(oldEnv)
+// onEnvironment removes the role bindings and roles from the namespace of the previously reconciled environment if
+// it has been deleted or moved to another namespace, then upserts the role bindings for the current environment
+func (o *RoleOptions) onEnvironment(oldEnv, newEnv *v1.Environment) error {
+	if oldEnv != nil {
+		if newEnv == nil || newEnv.Spec.Namespace != oldEnv.Spec.Namespace {
+			o.removeEnvironmentRoleBinding(oldEnv)
+			err := o.removeEnvironmentRoles(oldEnv)
+			if err != nil {
+				return errors.Wrapf(err, "failed to remove the roles of environment %s", oldEnv.Name)
 			}
 		}
 	}
 	if newEnv != nil {
 		err := o.upsertEnvironment(newEnv)
 		if err != nil {
-			log.Logger().Warnf("Failed to upsert role bindings for environment %s: %s", newEnv.Name, err)
+			return errors.Wrapf(err, "failed to upsert role bindings for environment %s", newEnv.Name)
 		}
 	}
+	return nil
 }
 
 func (o *RoleOptions) upsertEnvironment(env *v1.Environment) error {
@@ -222,33 +533,81 @@ func (o *RoleOptions) upsertEnvironment(env *v1.Environment) error {
 	var errorMap []error
 	ns := env.Spec.Namespace
 	if ns != "" {
-		for _, binding := range o.EnvRoleBindings {
+		for _, binding := range o.listEnvironmentRoleBindings() {
 			err := o.upsertEnvironmentRoleBindingRolesInEnvironments(env, binding, ns)
 			if err != nil {
 				errorMap = append(errorMap, err)
 			}
-
+			o.writeStatus(binding)
 		}
 	}
-	return util.CombineErrors(errorMap...)
+	err := util.CombineErrors(errorMap...)
+	if err != nil {
+		o.event(env, corev1.EventTypeWarning, reasonSyncFailed, "Failed to propagate the EnvironmentRoleBindings to namespace %s: %s", ns, err)
+	}
+	return err
 }
 
 // upsertEnvironmentRoleBindingRolesInEnvironments for the given environment and environment role binding lets update any role or role bindings if required
 func (o *RoleOptions) upsertEnvironmentRoleBindingRolesInEnvironments(env *v1.Environment, binding *v1.EnvironmentRoleBinding, ns string) error {
 	log.Logger().Infof("upserting environment role binding roles in environments in %s namespace", ns)
 	var errorMap []error
-	if kube.EnvironmentMatchesAny(env, binding.Spec.Environments) {
-		var err error
-		if ns != o.TeamNs {
-			roleName := binding.Spec.RoleRef.Name
-			role := o.Roles[roleName]
+	if kube.BindingMatchesEnvironment(env, binding) {
+		var err, roleErr error
+		roleRef := binding.Spec.RoleRef
+		if invalid := validateRoleRef(roleRef); invalid != nil {
+			log.Logger().Warnf("Not propagating environment role binding %s: %s", binding.Name, invalid)
+			o.event(binding, corev1.EventTypeWarning, reasonInvalidRoleRef, "Cannot propagate to namespace %s: %s", ns, invalid)
+			if ns != "" {
+				o.setNamespaceStatus(binding.Name, ns, env.Name, invalid, invalid)
+			}
+			return nil
+		}
+		if roleRef.Kind == kindClusterRole && !o.clusterRoleAllowed(roleRef.Name) {
+			refused := errors.Errorf("cluster role %s is not one of the allowed cluster roles", roleRef.Name)
+			log.Logger().Warnf("Not propagating environment role binding %s: %s", binding.Name, refused)
+			o.event(binding, corev1.EventTypeWarning, reasonClusterRoleNotAllowed, "ClusterRole %s is not allowed so it cannot be bound in namespace %s",
+				roleRef.Name, ns)
+			if ns == "" {
+				return nil
+			}
+			o.setNamespaceStatus(binding.Name, ns, env.Name, refused, refused)
+			// revoke any RoleBinding made before the ClusterRole was removed from the allowed cluster roles
+			return util.FilterOut(o.deleteOwnedRoleBinding(ns, binding.Name, binding), isOwnershipConflict)
+		}
+		if roleRef.Kind == kindClusterRole {
+			// ClusterRoles apply in every namespace so there is nothing to copy, but we only bind ones which exist
+			_, err = o.getClusterRole(roleRef.Name)
+			if err != nil {
+				if apierrors.IsNotFound(err) {
+					log.Logger().Warnf("Cannot find cluster role %s", roleRef.Name)
+					o.event(binding, corev1.EventTypeWarning, reasonClusterRoleNotFound, "ClusterRole %s not found so it cannot be bound in namespace %s",
+						roleRef.Name, ns)
+					err = errors.Errorf("cluster role %s not found", roleRef.Name)
+				} else {
+					err = errors.Wrapf(err, "getting cluster role %s", roleRef.Name)
+					errorMap = append(errorMap, err)
+				}
+				if ns != "" {
+					o.setNamespaceStatus(binding.Name, ns, env.Name, err, err)
+				}
+				return util.CombineErrors(errorMap...)
+			}
+		} else if ns != o.TeamNs {
+			roleName := roleRef.Name
+			role := o.getRole(roleName)
 			if role == nil {
 				log.Logger().Warnf("Cannot find role %s in namespace %s", roleName, o.TeamNs)
+				o.event(binding, corev1.EventTypeWarning, reasonRoleNotFound, "Role %s not found in namespace %s so it cannot be propagated to namespace %s",
+					roleName, o.TeamNs, ns)
+				roleErr = errors.Errorf("role %s not found in namespace %s", roleName, o.TeamNs)
 			} else {
-				roles := o.KubeClient.RbacV1().Roles(ns)
-				err = o.updateOrCreateRole(roles, role, roleName, ns)
+				err = o.updateOrCreateRole(role, roleName, ns, binding)
+				roleErr = err
 			}
 		}
+		// ownership conflicts are recorded in the status rather than retried
+		err = util.FilterOut(err, isOwnershipConflict)
 		if err != nil {
 			log.Logger().Warnf("Failed: %s", err)
 			errorMap = append(errorMap, err)
@@ -257,73 +616,184 @@ func (o *RoleOptions) upsertEnvironmentRoleBindingRolesInEnvironments(env *v1.En
 		bindingName := binding.Name
 		roleBindings := o.KubeClient.RbacV1().RoleBindings(ns)
 		var old *rbacv1.RoleBinding
+		var operation string
 		old, err = roleBindings.Get(bindingName, metav1.GetOptions{})
-		if err == nil && old != nil {
+		found := err == nil && old != nil
+		adopt := false
+		var conflict error
+		if found {
+			adopt, conflict = o.checkOwnership(kindRoleBinding, old, binding)
+		}
+		if conflict != nil {
+			err = conflict
+		} else if found && old.RoleRef != binding.Spec.RoleRef {
+			// the roleRef of a RoleBinding is immutable so it has to be recreated
+			operation = operationRecreate
+			err = o.recreateRoleBinding(binding, old, ns)
+		} else if o.ServerSideApply && !o.DryRun && !adopt {
+			log.Logger().Infof("Applying RoleBinding %s in namespace %s", bindingName, ns)
+			resourceVersion := ""
+			if found {
+				resourceVersion = old.ResourceVersion
+			}
+			var changed bool
+			changed, err = o.applyRoleBinding(ns, o.newRoleBinding(binding, ns, bindingName), resourceVersion)
+			if changed || err != nil {
+				operation = operationApply
+			}
+		} else if found {
 			// lets update it
-			changed := false
-
-			if !reflect.DeepEqual(old.RoleRef, binding.Spec.RoleRef) {
-				old.RoleRef = binding.Spec.RoleRef
-				changed = true
+			changed := adopt
+			if adopt {
+				o.adopt(old)
 			}
 			if !reflect.DeepEqual(old.Subjects, binding.Spec.Subjects) {
 				old.Subjects = binding.Spec.Subjects
 				changed = true
 			}
+			if reconcileMetadata(old, o.newRoleBinding(binding, ns, bindingName)) {
+				changed = true
+			}
 			if changed {
 				log.Logger().Infof("Updating RoleBinding %s in namespace %s", bindingName, ns)
-				_, err = roleBindings.Update(old)
+				operation = operationUpdate
+				err = o.updateRoleBinding(ns, old)
 			}
 		} else {
 			log.Logger().Infof("Creating RoleBinding %s in namespace %s", bindingName, ns)
-			newBinding := &rbacv1.RoleBinding{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: bindingName,
-					Labels: map[string]string{
-						kube.LabelCreatedBy: kube.ValueCreatedByJX,
-						kube.LabelTeam:      o.TeamNs,
-					},
-				},
-				Subjects: binding.Spec.Subjects,
-				RoleRef:  binding.Spec.RoleRef,
-			}
-			_, err = roleBindings.Create(newBinding)
+			operation = operationCreate
+			err = o.createRoleBinding(ns, o.newRoleBinding(binding, ns, bindingName))
+		}
+		if operation != "" {
+			o.writeEvent(kindRoleBinding, operation, ns, bindingName, err, binding)
+		}
+		if ns != "" {
+			o.setNamespaceStatus(binding.Name, ns, env.Name, roleErr, err)
+		}
+		err = util.FilterOut(err, isOwnershipConflict)
+		if err != nil {
+			log.Logger().Warnf("Failed: %s", err)
+			errorMap = append(errorMap, err)
+		}
+	} else if ns != "" && o.hasNamespaceStatus(binding, ns, env.Name) {
+		// the binding no longer matches the environment it was propagated to, e.g. after either was relabelled, so
+		// revoke what it granted there unless another environment in the namespace still matches it
+		bound, err := o.namespaceBoundByOtherEnvironment(ns, env.Name, binding)
+		if err == nil && !bound {
+			err = o.deleteOwnedRoleBinding(ns, binding.Name, binding)
 		}
 		if err != nil {
 			log.Logger().Warnf("Failed: %s", err)
 			errorMap = append(errorMap, err)
+		} else {
+			o.clearNamespaceStatus(binding.Name, ns, env.Name)
 		}
 	}
 	return util.CombineErrors(errorMap...)
 }
 
-func (o *RoleOptions) updateOrCreateRole(roles kv1.RoleInterface, role *rbacv1.Role, roleName, namespace string) error {
-	oldRole, err := roles.Get(roleName, metav1.GetOptions{})
+// recreateRoleBinding replaces the RoleBinding in the namespace whose roleRef no longer matches the
+// EnvironmentRoleBinding. Unless RoleRefNoGap is set the subjects briefly have neither role
+func (o *RoleOptions) recreateRoleBinding(binding *v1.EnvironmentRoleBinding, old *rbacv1.RoleBinding, ns string) error {
+	log.Logger().Infof("Recreating RoleBinding %s in namespace %s as its roleRef changed from %s %s to %s %s", old.Name, ns,
+		old.RoleRef.Kind, old.RoleRef.Name, binding.Spec.RoleRef.Kind, binding.Spec.RoleRef.Name)
+	o.event(binding, corev1.EventTypeNormal, reasonRoleRefChanged, "Recreating RoleBinding %s in namespace %s as its roleRef changed from %s %s to %s %s",
+		old.Name, ns, old.RoleRef.Kind, old.RoleRef.Name, binding.Spec.RoleRef.Kind, binding.Spec.RoleRef.Name)
+
+	desired := o.newRoleBinding(binding, ns, old.Name)
+	var bridge *rbacv1.RoleBinding
+	if o.RoleRefNoGap {
+		bridge = o.newRoleBinding(binding, ns, old.Name+roleRefBridgeSuffix)
+		err := o.createRoleBinding(ns, bridge)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "creating temporary RoleBinding %s in namespace %s", bridge.Name, ns)
+		}
+	}
+	err := o.deleteRoleBinding(ns, old.Name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "deleting RoleBinding %s in namespace %s to change its roleRef", old.Name, ns)
+	}
+	err = o.createRoleBinding(ns, desired)
+	if err != nil {
+		// if we created a temporary RoleBinding it keeps granting access until a later reconcile succeeds
+		return errors.Wrapf(err, "recreating RoleBinding %s in namespace %s with the new roleRef", old.Name, ns)
+	}
+	if bridge != nil {
+		err = o.deleteRoleBinding(ns, bridge.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "deleting temporary RoleBinding %s in namespace %s", bridge.Name, ns)
+		}
+	}
+	return nil
+}
+
+// clusterRoleAllowed returns true if EnvironmentRoleBindings may bind the ClusterRole
+func (o *RoleOptions) clusterRoleAllowed(name string) bool {
+	for _, allowed := range o.AllowedClusterRoles {
+		if allowed == allowAnyClusterRole || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// updateOrCreateRole copies the team Role into the namespace if it has changed, recording events against the Role
+// and any other objects which caused the copy
+func (o *RoleOptions) updateOrCreateRole(role *rbacv1.Role, roleName, namespace string, sources ...runtime.Object) error {
+	sources = append([]runtime.Object{role}, sources...)
+	rules, err := o.propagatedRules(role)
+	if err != nil {
+		return err
+	}
+	oldRole, err := o.KubeClient.RbacV1().Roles(namespace).Get(roleName, metav1.GetOptions{})
+	found := err == nil && oldRole != nil
+	adopt := false
+	if found {
+		adopt, err = o.checkOwnership(kindRole, oldRole, sources...)
+		if err != nil {
+			return err
+		}
+	}
+	// an adopted Role is updated so that its ownership labels are written before we apply it
+	if o.ServerSideApply && !o.DryRun && !adopt {
+		log.Logger().Infof("Applying Role %s in namespace %s", roleName, namespace)
+		resourceVersion := ""
+		if found {
+			resourceVersion = oldRole.ResourceVersion
+		}
+		changed, err := o.applyRole(namespace, o.newRole(role, roleName, rules), resourceVersion)
+		if changed || err != nil {
+			o.writeEvent(kindRole, operationApply, namespace, roleName, err, sources...)
+		}
+		return err
+	}
 	log.Logger().Infof("updating or creating role %s in namespace %s", roleName, namespace)
-	if err == nil && oldRole != nil {
+	var operation string
+	if found {
 		// lets update it
-		changed := false
-		if !reflect.DeepEqual(oldRole.Rules, role.Rules) {
-			oldRole.Rules = role.Rules
+		changed := adopt
+		if adopt {
+			o.adopt(oldRole)
+		}
+		if !reflect.DeepEqual(oldRole.Rules, rules) {
+			oldRole.Rules = rules
+			changed = true
+		}
+		if reconcileMetadata(oldRole, o.newRole(role, roleName, rules)) {
 			changed = true
 		}
 		if changed {
 			log.Logger().Infof("Updating Role %s in namespace %s", roleName, namespace)
-			_, err = roles.Update(oldRole)
+			operation = operationUpdate
+			err = o.updateRole(namespace, oldRole)
 		}
 	} else {
 		log.Logger().Infof("Creating Role %s in namespace %s", roleName, namespace)
-		newRole := &rbacv1.Role{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: roleName,
-				Labels: map[string]string{
-					kube.LabelCreatedBy: kube.ValueCreatedByJX,
-					kube.LabelTeam:      o.TeamNs,
-				},
-			},
-			Rules: role.Rules,
-		}
-		_, err = roles.Create(newRole)
+		operation = operationCreate
+		err = o.createRole(namespace, o.newRole(role, roleName, rules))
+	}
+	if operation != "" {
+		o.writeEvent(kindRole, operation, namespace, roleName, err, sources...)
 	}
 	return err
 }
@@ -332,54 +802,62 @@ func (o *RoleOptions) removeEnvironmentRoleBinding(env *v1.Environment) {
 	log.Logger().Infof("removing environment role binding for %s", env.Name)
 	ns := env.Spec.Namespace
 	if ns != "" {
-		for _, binding := range o.EnvRoleBindings {
-			if kube.EnvironmentMatchesAny(env, binding.Spec.Environments) {
-				err := o.KubeClient.RbacV1().RoleBindings(ns).Delete(binding.Name, nil)
+		for _, binding := range o.listEnvironmentRoleBindings() {
+			if kube.BindingMatchesEnvironment(env, binding) {
+				bound, err := o.namespaceBoundByOtherEnvironment(ns, env.Name, binding)
+				if err == nil && !bound {
+					err = o.deleteOwnedRoleBinding(ns, binding.Name, env)
+				}
 				if err != nil {
 					log.Logger().Errorf("error deleting role binding from env: %s", binding.Name)
 				}
+				o.clearNamespaceStatus(binding.Name, ns, env.Name)
+				o.writeStatus(binding)
 			}
 		}
 	}
 }
 
-func (o *RoleOptions) onEnvironmentRoleBinding(oldObj, newObj interface{}) {
-	if o.EnvRoleBindings == nil {
-		o.EnvRoleBindings = map[string]*v1.EnvironmentRoleBinding{}
-	}
-	if oldObj != nil {
-		oldEnv := oldObj.(*v1.EnvironmentRoleBinding)
-		if oldEnv != nil {
-			delete(o.EnvRoleBindings, oldEnv.Name)
-		}
-	}
-	if newObj != nil {
-		newEnv := newObj.(*v1.EnvironmentRoleBinding)
-		err := o.UpsertEnvironmentRoleBinding(newEnv)
-		if err != nil {
-			log.Logger().Warnf("when upserting environment role binding %v", err)
-		}
+func (o *RoleOptions) onEnvironmentRoleBinding(name string, binding *v1.EnvironmentRoleBinding) error {
+	if binding == nil {
+		// the binding was deleted without our finalizer so lets clean up whatever we can find by name
+		o.forgetEnvironmentRoleBinding(name)
+		o.forgetStatus(name)
+		return o.removeEnvironmentRoleBindingFromEnvironments(name, nil)
 	}
+	return o.UpsertEnvironmentRoleBinding(binding)
 }
 
 // UpsertEnvironmentRoleBinding processes an insert/update of the EnvironmentRoleBinding resource
 // its public so that we can make testing easier
 func (o *RoleOptions) UpsertEnvironmentRoleBinding(newEnv *v1.EnvironmentRoleBinding) error {
 	log.Logger().Info("upserting environment role binding")
-	if newEnv != nil {
-		if o.EnvRoleBindings == nil {
-			o.EnvRoleBindings = map[string]*v1.EnvironmentRoleBinding{}
+	o.initStores()
+	if newEnv.DeletionTimestamp != nil {
+		return o.DeleteEnvironmentRoleBinding(newEnv)
+	}
+	// only add the finalizer when watching as otherwise nothing would ever remove it, a dry run never writes it
+	if !o.NoWatch && !o.DryRun {
+		var err error
+		newEnv, err = o.ensureFinalizer(newEnv)
+		if err != nil {
+			return err
 		}
-		o.EnvRoleBindings[newEnv.Name] = newEnv
+	}
+	o.storeEnvironmentRoleBinding(newEnv)
+	if _, err := kube.EnvironmentSelector(newEnv); err != nil {
+		log.Logger().Warnf("Environment role binding %s matches no environments: %s", newEnv.Name, err)
+		o.event(newEnv, corev1.EventTypeWarning, reasonInvalidEnvironmentSelector, "Matches no environments: %s", err)
 	}
 
 	// now lets update any roles in any environment we may need to change
-	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(metav1.ListOptions{})
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
 	if err != nil {
 		return err
 	}
 
 	var errorMap []error
+	o.forgetStatus(newEnv.Name)
 	for idx := 0; idx < len(envList.Items); idx++ {
 		env := &envList.Items[idx]
 		err = o.upsertEnvironmentRoleBindingRolesInEnvironments(env, newEnv, env.Spec.Namespace)
@@ -387,26 +865,15 @@ func (o *RoleOptions) UpsertEnvironmentRoleBinding(newEnv *v1.EnvironmentRoleBin
 			errorMap = append(errorMap, err)
 		}
 	}
+	o.writeStatus(newEnv)
 	return util.CombineErrors(errorMap...)
 }
 
-func (o *RoleOptions) onRole(oldObj, newObj interface{}) {
-	if o.Roles == nil {
-		o.Roles = map[string]*rbacv1.Role{}
-	}
-	if oldObj != nil {
-		oldRole := oldObj.(*rbacv1.Role)
-		if oldRole != nil {
-			delete(o.Roles, oldRole.Name)
-		}
-	}
-	if newObj != nil {
-		newRole := newObj.(*rbacv1.Role)
-		err := o.UpsertRole(newRole)
-		if err != nil {
-			log.Logger().Errorf("when upserting role: %s", newRole.Name)
-		}
+func (o *RoleOptions) onRole(name string, role *rbacv1.Role) error {
+	if role == nil {
+		return o.DeleteRole(name)
 	}
+	return o.UpsertRole(role)
 }
 
 // UpsertRole processes the insert/update of a Role
@@ -416,17 +883,20 @@ func (o *RoleOptions) UpsertRole(newRole *rbacv1.Role) error {
 	if newRole == nil {
 		return nil
 	}
-	if o.Roles == nil {
-		o.Roles = map[string]*rbacv1.Role{}
-	}
-	o.Roles[newRole.Name] = newRole
+	o.initStores()
+	oldRole := o.reconciledRole(newRole.Name)
+	o.storeRole(newRole)
 
-	if newRole.Labels == nil || newRole.Labels[kube.LabelKind] != kube.ValueKindEnvironmentRole {
-		return nil
+	if !kube.IsEnvironmentRole(newRole.Labels) {
+		if oldRole != nil && kube.IsEnvironmentRole(oldRole.Labels) {
+			// the role is no longer an EnvironmentRole so lets remove the copies we made of it
+			return o.removeRoleFromEnvironments(newRole.Name, true, newRole)
+		}
+		return o.upsertBindingsMissingRole(newRole.Name)
 	}
 
 	// now lets update any roles in any environment we may need to change
-	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(metav1.ListOptions{})
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
 	if err != nil {
 		return err
 	}
@@ -438,6 +908,25 @@ func (o *RoleOptions) UpsertRole(newRole *rbacv1.Role) error {
 			errorMap = append(errorMap, err)
 		}
 	}
+	errorMap = append(errorMap, o.upsertBindingsMissingRole(newRole.Name))
+	return util.CombineErrors(errorMap...)
+}
+
+// upsertBindingsMissingRole upserts the EnvironmentRoleBindings referencing the Role again if they could not copy it
+// into a namespace when last reconciled, e.g. as the Role did not exist yet, so that their status catches up
+func (o *RoleOptions) upsertBindingsMissingRole(name string) error {
+	var errorMap []error
+	for _, binding := range o.listEnvironmentRoleBindings() {
+		if binding.Spec.RoleRef.Kind == kindClusterRole || binding.Spec.RoleRef.Name != name {
+			continue
+		}
+		for _, status := range o.namespaceStatuses(binding.Name) {
+			if !status.RoleInSync {
+				errorMap = append(errorMap, o.UpsertEnvironmentRoleBinding(binding))
+				break
+			}
+		}
+	}
 	return util.CombineErrors(errorMap...)
 }
 
@@ -447,19 +936,16 @@ func (o *RoleOptions) upsertRoleInEnvironments(role *rbacv1.Role, ns string) err
 	if ns == o.TeamNs {
 		return nil
 	}
-	var err error
-	roles := o.KubeClient.RbacV1().Roles(ns)
-	err = o.updateOrCreateRole(roles, role, role.Name, ns)
-	return err
+	return util.FilterOut(o.updateOrCreateRole(role, role.Name, ns), isOwnershipConflict)
 }
 
 func (o *RoleOptions) upsertRoleIntoEnvRole() {
 	log.Logger().Info("upserting role into environment role")
 	foundRole := 0
-	for _, roleValue := range o.Roles {
+	for _, roleValue := range o.listRoles() {
 		for labelK, labelV := range roleValue.Labels {
 			if util.StringMatchesPattern(labelK, kube.LabelKind) && util.StringMatchesPattern(labelV, kube.ValueKindEnvironmentRole) {
-				for _, envRoleValue := range o.EnvRoleBindings {
+				for _, envRoleValue := range o.listEnvironmentRoleBindings() {
 					if util.StringMatchesPattern(strings.Trim(roleValue.GetName(), blankSting), strings.Trim(envRoleValue.Spec.RoleRef.Name, blankSting)) {
 						foundRole = 1
 						break
@@ -483,7 +969,8 @@ func (o *RoleOptions) upsertRoleIntoEnvRole() {
 							},
 						},
 					}
-					_, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(o.TeamNs).Create(newEnvRoleBinding)
+					err := o.createEnvironmentRoleBinding(newEnvRoleBinding)
+					o.writeEvent(kindEnvironmentRoleBinding, operationCreate, o.TeamNs, newEnvRoleBinding.Name, err, roleValue)
 					if err != nil {
 						log.Logger().Errorf("when upserting role into environment role: %s, with error: %s", newEnvRoleBinding.Name, err)
 					}
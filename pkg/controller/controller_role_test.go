@@ -1,6 +1,7 @@
 package controller_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -100,7 +101,7 @@ func Test_EnvironmentRoleBinding(t *testing.T) {
 		},
 	)
 
-	err := o.Run()
+	err := o.Run(context.Background())
 	assert.NoError(t, err)
 
 	nsNames := []string{teamNs, teamNs + "-staging", teamNs + "-production", teamNs + "-preview-jx-jstrachan-demo96-pr-1", teamNs + "-preview-jx-jstrachan-another-pr-3"}
@@ -0,0 +1,310 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var driftRules = []rbacv1.PolicyRule{
+	{
+		Verbs:     []string{"get", "watch", "list"},
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+	},
+}
+
+func newDriftOptions() *controller.RoleOptions {
+	return &controller.RoleOptions{
+		NoWatch: true,
+		Metadata: controller.MetadataOptions{
+			AllowPrefixes: []string{"cost-centre", "example.com/"},
+		},
+	}
+}
+
+func newDriftSourceRole() *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: "jx",
+			UID:       "role-uid",
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+				"cost-centre":  "cc-1",
+			},
+			Annotations: map[string]string{
+				"example.com/owner": "alice",
+			},
+		},
+		Rules: driftRules,
+	}
+}
+
+// countUpdates returns the number of updates of the given resource in the namespace
+func countUpdates(kubeClient *fake.Clientset, resource, ns string) int {
+	count := 0
+	for _, action := range kubeClient.Actions() {
+		if action.GetVerb() == "update" && action.GetNamespace() == ns && action.GetResource().Resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+// withoutManagedMetadata returns the annotations without the one recording the managed metadata
+func withoutManagedMetadata(annotations map[string]string) map[string]string {
+	answer := map[string]string{}
+	for k, v := range annotations {
+		if k != kube.AnnotationManagedMetadata {
+			answer[k] = v
+		}
+	}
+	return answer
+}
+
+func Test_RoleMetadataDrift(t *testing.T) {
+	t.Parallel()
+	teamNs := "jx"
+	ns := "jx-staging"
+	expectedLabels := map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      teamNs,
+		"cost-centre":       "cc-1",
+	}
+	expectedAnnotations := map[string]string{
+		"example.com/owner":            "alice",
+		kube.AnnotationSourceNamespace: teamNs,
+		kube.AnnotationSourceKind:      "Role",
+		kube.AnnotationSourceName:      "myrole",
+		kube.AnnotationSourceUID:       "role-uid",
+	}
+	merge := func(maps ...map[string]string) map[string]string {
+		answer := map[string]string{}
+		for _, m := range maps {
+			for k, v := range m {
+				answer[k] = v
+			}
+		}
+		return answer
+	}
+
+	testCases := []struct {
+		name                string
+		labels              map[string]string
+		annotations         map[string]string
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name: "legacy Role without team label",
+			labels: map[string]string{
+				kube.LabelCreatedBy: kube.ValueCreatedByJX,
+			},
+			expectedLabels:      expectedLabels,
+			expectedAnnotations: expectedAnnotations,
+		},
+		{
+			name: "drifted label value",
+			labels: merge(expectedLabels, map[string]string{
+				"cost-centre": "cc-old",
+			}),
+			annotations:         expectedAnnotations,
+			expectedLabels:      expectedLabels,
+			expectedAnnotations: expectedAnnotations,
+		},
+		{
+			name: "label no longer propagated",
+			labels: merge(expectedLabels, map[string]string{
+				"cost-centre-retired": "cc-0",
+			}),
+			annotations: merge(expectedAnnotations, map[string]string{
+				"example.com/retired":          "yes",
+				kube.AnnotationManagedMetadata: `{"labels":["cost-centre-retired","jenkins.io/created-by","team"],"annotations":["example.com/retired"]}`,
+			}),
+			expectedLabels:      expectedLabels,
+			expectedAnnotations: expectedAnnotations,
+		},
+		{
+			name: "foreign labels and annotations",
+			labels: merge(expectedLabels, map[string]string{
+				"app":         "mine",
+				"cost-centre": "cc-old",
+			}),
+			annotations: map[string]string{
+				"example.com/note": "added by hand",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			},
+			expectedLabels: merge(expectedLabels, map[string]string{
+				"app": "mine",
+			}),
+			expectedAnnotations: merge(expectedAnnotations, map[string]string{
+				"example.com/note": "added by hand",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			}),
+		},
+		{
+			name:   "drifted source annotation",
+			labels: expectedLabels,
+			annotations: merge(expectedAnnotations, map[string]string{
+				kube.AnnotationSourceName: "otherrole",
+			}),
+			expectedLabels:      expectedLabels,
+			expectedAnnotations: expectedAnnotations,
+		},
+	}
+
+	for _, tc := range testCases {
+		o := newDriftOptions()
+		testhelpers.ConfigureTestOptionsWithResources(o,
+			[]runtime.Object{
+				newDriftSourceRole(),
+				&rbacv1.Role{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "myrole",
+						Namespace:   ns,
+						Labels:      tc.labels,
+						Annotations: tc.annotations,
+					},
+					Rules: driftRules,
+				},
+			},
+			[]runtime.Object{
+				kube.NewPermanentEnvironment("staging"),
+			},
+		)
+		kubeClient := o.KubeClient.(*fake.Clientset)
+		kubeClient.ClearActions()
+
+		err := o.Run(context.Background())
+		require.NoError(t, err, "%s", tc.name)
+
+		r, err := o.KubeClient.RbacV1().Roles(ns).Get("myrole", metav1.GetOptions{})
+		require.NoError(t, err, "%s", tc.name)
+		assert.Equal(t, tc.expectedLabels, r.Labels, "labels for %s", tc.name)
+		assert.Equal(t, tc.expectedAnnotations, withoutManagedMetadata(r.Annotations), "annotations for %s", tc.name)
+		assert.Equal(t, driftRules, r.Rules, "rules for %s", tc.name)
+		assert.Equal(t, 1, countUpdates(kubeClient, "roles", ns), "updates of the Role for %s", tc.name)
+	}
+}
+
+func Test_NoMetadataDrift(t *testing.T) {
+	t.Parallel()
+	o := newDriftOptions()
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			newDriftSourceRole(),
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+		},
+	)
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	kubeClient := o.KubeClient.(*fake.Clientset)
+	kubeClient.ClearActions()
+
+	err = o.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, countUpdates(kubeClient, "roles", "jx-staging"), "updates of the Role without drift")
+}
+
+func Test_RoleBindingOwnerReferenceDrift(t *testing.T) {
+	t.Parallel()
+	o := newDriftOptions()
+	teamNs := "jx"
+	isController := true
+	foreignRef := metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       "someone-else",
+		UID:        "foreign-uid",
+	}
+	staleRef := metav1.OwnerReference{
+		APIVersion: v1.SchemeGroupVersion.String(),
+		Kind:       "EnvironmentRoleBinding",
+		Name:       "oldbinding",
+		UID:        "stale-uid",
+		Controller: &isController,
+	}
+	expectedRef := metav1.OwnerReference{
+		APIVersion: v1.SchemeGroupVersion.String(),
+		Kind:       "EnvironmentRoleBinding",
+		Name:       "mybinding",
+		UID:        "binding-uid",
+		Controller: &isController,
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			newDriftSourceRole(),
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelCreatedBy: kube.ValueCreatedByJX,
+						kube.LabelTeam:      teamNs,
+					},
+					Annotations: map[string]string{
+						kube.AnnotationManagedMetadata: `{"ownerReferences":["stale-uid"]}`,
+					},
+					OwnerReferences: []metav1.OwnerReference{foreignRef, staleRef},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "Role",
+					Name:     "myrole",
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+					UID:       "binding-uid",
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	rb, err := o.KubeClient.RbacV1().RoleBindings(teamNs).Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []metav1.OwnerReference{foreignRef, expectedRef}, rb.OwnerReferences, "owner references of the RoleBinding in the team namespace")
+	assert.Equal(t, `{"labels":["jenkins.io/created-by","team"],"annotations":["jenkins.io/source-kind","jenkins.io/source-name","jenkins.io/source-namespace","jenkins.io/source-uid"],"ownerReferences":["binding-uid"]}`,
+		rb.Annotations[kube.AnnotationManagedMetadata], "managed metadata of the RoleBinding in the team namespace")
+
+	rb, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, rb.OwnerReferences, "owner references cannot cross namespaces")
+}
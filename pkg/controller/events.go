@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"strings"
+
+	jxscheme "github.com/jenkins-x/jx-api/pkg/client/clientset/versioned/scheme"
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventComponent = "jx-role-controller"
+
+	// reasonRoleRefChanged the RoleBinding was recreated as the roleRef of the EnvironmentRoleBinding changed
+	reasonRoleRefChanged = "RoleRefChanged"
+	// reasonRoleNotFound the EnvironmentRoleBinding refers to a Role which does not exist in the team namespace
+	reasonRoleNotFound = "RoleNotFound"
+	// reasonClusterRoleNotFound the EnvironmentRoleBinding refers to a ClusterRole which does not exist
+	reasonClusterRoleNotFound = "ClusterRoleNotFound"
+	// reasonClusterRoleNotAllowed the EnvironmentRoleBinding refers to, or the EnvironmentRole aggregates, a ClusterRole
+	// which is not one of the allowed cluster roles
+	reasonClusterRoleNotAllowed = "ClusterRoleNotAllowed"
+	// reasonNonResourceRulesIgnored a ClusterRole aggregated into an EnvironmentRole has rules for non-resource URLs,
+	// which only a ClusterRole can grant so they are left out of the propagated Roles
+	reasonNonResourceRulesIgnored = "NonResourceRulesIgnored"
+	// reasonInvalidRoleRef the roleRef of the EnvironmentRoleBinding is neither a Role nor a ClusterRole
+	reasonInvalidRoleRef = "InvalidRoleRef"
+	// reasonInvalidEnvironmentSelector the environment selector annotation of the EnvironmentRoleBinding cannot be parsed
+	reasonInvalidEnvironmentSelector = "InvalidEnvironmentSelector"
+	// reasonOwnershipConflict a Role or RoleBinding in an environment namespace is owned by another team
+	reasonOwnershipConflict = "OwnershipConflict"
+	// reasonForeignObject a Role or RoleBinding in an environment namespace has the name of one we propagate but was not
+	// created by the controller
+	reasonForeignObject = "ForeignObject"
+	// reasonSyncFailed the RBAC of the Environment could not be brought up to date
+	reasonSyncFailed = "SyncFailed"
+)
+
+// pastTense is used to build the reason of the event for a successful write, e.g. RoleCreated
+var pastTense = map[string]string{
+	operationCreate:   "Created",
+	operationUpdate:   "Updated",
+	operationDelete:   "Deleted",
+	operationApply:    "Applied",
+	operationRecreate: "Recreated",
+}
+
+// eventScheme knows about both the Kubernetes and Jenkins X types so events can refer to any object we process
+var eventScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(scheme.AddToScheme(eventScheme))
+	utilruntime.Must(jxscheme.AddToScheme(eventScheme))
+}
+
+// event records an event against the object, unless in dry-run mode where nothing is written to the cluster
+func (o *RoleOptions) event(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if o.DryRun || obj == nil {
+		return
+	}
+	o.recorderOnce.Do(func() {
+		if o.Recorder != nil {
+			return
+		}
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartLogging(log.Logger().Debugf)
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: o.KubeClient.CoreV1().Events("")})
+		o.Recorder = broadcaster.NewRecorder(eventScheme, corev1.EventSource{Component: eventComponent})
+	})
+	o.Recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
+// writeEvent records the outcome of a write to a Role or RoleBinding in an environment namespace against each of the
+// source objects which caused it, e.g. RoleCreated or UpdateFailed
+func (o *RoleOptions) writeEvent(kind, operation, ns, name string, err error, sources ...runtime.Object) {
+	for _, source := range sources {
+		if err != nil {
+			o.event(source, corev1.EventTypeWarning, strings.Title(operation)+"Failed", "Failed to %s %s %s in namespace %s: %s",
+				operation, kind, name, ns, err)
+		} else {
+			o.event(source, corev1.EventTypeNormal, kind+pastTense[operation], "%s %s %s in namespace %s",
+				pastTense[operation], kind, name, ns)
+		}
+	}
+}
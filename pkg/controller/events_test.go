@@ -0,0 +1,122 @@
+package controller_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_Events(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:  true,
+		Recorder: recorder,
+	}
+	teamNs := "jx"
+	newBinding := func(name, roleName string) *v1.EnvironmentRoleBinding {
+		return &v1.EnvironmentRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+			},
+			Spec: v1.EnvironmentRoleBindingSpec{
+				Subjects: []rbacv1.Subject{
+					{
+						Kind:      "ServiceAccount",
+						Name:      "jenkins",
+						Namespace: teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "Role",
+					Name:     roleName,
+				},
+				Environments: []v1.EnvironmentFilter{
+					{
+						Includes: []string{"staging", "production"},
+					},
+				},
+			},
+		}
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			kube.NewPermanentEnvironment("production"),
+			newBinding("mybinding", "myrole"),
+			newBinding("missing", "missingrole"),
+		},
+	)
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+	assertEvents(t, drainEvents(recorder),
+		"Normal RoleCreated Created Role myrole in namespace jx-staging",
+		"Normal RoleBindingCreated Created RoleBinding mybinding in namespace jx-staging",
+		"Warning RoleNotFound Role missingrole not found in namespace jx so it cannot be propagated to namespace jx-staging",
+	)
+
+	o.KubeClient.(*fake.Clientset).PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"}, "forbidden", nil)
+	})
+	err = o.UpsertEnvironmentRoleBinding(newBinding("forbidden", "myrole"))
+	assert.Error(t, err)
+	assertEvents(t, drainEvents(recorder),
+		"Warning CreateFailed Failed to create RoleBinding forbidden in namespace jx-production: rolebindings.rbac.authorization.k8s.io \"forbidden\" is forbidden",
+	)
+}
+
+// assertEvents asserts that an event starting with each of the expected prefixes was recorded
+func assertEvents(t *testing.T, events []string, expected ...string) {
+	for _, prefix := range expected {
+		found := false
+		for _, event := range events {
+			if strings.HasPrefix(event, prefix) {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, "expected an event starting with %q in %v", prefix, events)
+	}
+}
+
+// drainEvents returns the events recorded so far
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var answer []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			answer = append(answer, event)
+		default:
+			return answer
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"net/http/httptest"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// hooks into the work queue for the tests, which otherwise only the watches drive
+
+// MaxRetries is the number of times a work item is retried before it is dropped
+const MaxRetries = maxRetries
+
+// UseTestQueue replaces the work queue and watch stores so that the tests can enqueue resources themselves
+func (o *RoleOptions) UseTestQueue(rateLimiter workqueue.RateLimiter) {
+	o.initStores()
+	o.queue = workqueue.NewRateLimitingQueue(rateLimiter)
+	o.stores = map[string]cache.Store{}
+	for _, resource := range []string{roles, environments, environmentrolebindings, clusterroles} {
+		o.stores[resource] = cache.NewIndexer(cache.DeletionHandlingMetaNamespaceKeyFunc,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	o.listFromWatches()
+}
+
+// Enqueue adds the object to the watch store of the resource and enqueues it
+func (o *RoleOptions) Enqueue(resource string, obj interface{}) error {
+	err := o.stores[resource].Add(obj)
+	if err != nil {
+		return err
+	}
+	o.enqueue(resource, obj)
+	return nil
+}
+
+// ProcessNextWorkItem reconciles the next item on the work queue, blocking until there is one
+func (o *RoleOptions) ProcessNextWorkItem() bool {
+	return o.processNextWorkItem(make(chan struct{}))
+}
+
+// QueueLen returns the number of items waiting on the work queue
+func (o *RoleOptions) QueueLen() int {
+	return o.queue.Len()
+}
+
+// NumRequeues returns how many times the resource has been requeued after failing
+func (o *RoleOptions) NumRequeues(resource, key string) int {
+	return o.queue.NumRequeues(workItem{resource: resource, key: key})
+}
+
+// NewTeam returns the controller of the team namespace used in multi-team mode
+func (o *RoleOptions) NewTeam(ns string) *RoleOptions {
+	return o.newTeam(ns)
+}
+
+// IsReady returns true once the controller reports ready
+func (o *RoleOptions) IsReady() bool {
+	return o.health.isReady()
+}
+
+// Readyz returns the status code and body of the readiness endpoint
+func (o *RoleOptions) Readyz() (int, string) {
+	recorder := httptest.NewRecorder()
+	o.health.readyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+// Healthz returns the status code and body of the liveness endpoint
+func (o *RoleOptions) Healthz() (int, string) {
+	recorder := httptest.NewRecorder()
+	o.health.healthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	return recorder.Code, recorder.Body.String()
+}
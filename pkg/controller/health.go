@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// health tracks the state reported by the liveness and readiness endpoints
+type health struct {
+	lock    sync.RWMutex
+	ready   bool
+	standby bool
+	// livenessTimeout is how long the watches may keep failing or a reconcile may run before we report unhealthy,
+	// zero disables the checks
+	livenessTimeout time.Duration
+	// watches are the list and watch calls of each watched resource
+	watches map[string]*watchHealth
+	// reconciling are the start times of the in-flight reconciles
+	reconciling map[workItem]time.Time
+	// children are the health of the team controllers in multi-team mode
+	children map[*health]bool
+}
+
+// watchHealth records the last successful list or watch of a resource and whether the calls since then failed
+type watchHealth struct {
+	lastSuccess time.Time
+	failing     bool
+}
+
+// setReady marks the controller as ready once the informers have synced and the startup reconciliation has finished
+func (h *health) setReady(ready bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.ready = ready
+}
+
+// setStandby marks the controller as waiting to acquire the leader election lease
+func (h *health) setStandby(standby bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.standby = standby
+}
+
+// setLivenessTimeout sets how long the watches may keep failing or a reconcile may run before we report unhealthy
+func (h *health) setLivenessTimeout(timeout time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.livenessTimeout = timeout
+}
+
+// trackListWatch records the outcome of the list and watch calls of the resource. The watch counts as failing until
+// its first successful list, so one which never manages to list goes stale too
+func (h *health) trackListWatch(resource string, listWatch *cache.ListWatch) {
+	h.lock.Lock()
+	if h.watches == nil {
+		h.watches = map[string]*watchHealth{}
+	}
+	h.watches[resource] = &watchHealth{lastSuccess: time.Now(), failing: true}
+	h.lock.Unlock()
+
+	listFunc := listWatch.ListFunc
+	listWatch.ListFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		result, err := listFunc(options)
+		h.listWatchCalled(resource, err)
+		return result, err
+	}
+	watchFunc := listWatch.WatchFunc
+	listWatch.WatchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+		result, err := watchFunc(options)
+		h.listWatchCalled(resource, err)
+		return result, err
+	}
+}
+
+// listWatchCalled records the outcome of a list or watch call of the resource
+func (h *health) listWatchCalled(resource string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	w := h.watches[resource]
+	if err != nil {
+		w.failing = true
+		return
+	}
+	w.lastSuccess = time.Now()
+	w.failing = false
+}
+
+// reconcileStarted records the start of the reconcile of the work item
+func (h *health) reconcileStarted(item workItem, start time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.reconciling == nil {
+		h.reconciling = map[workItem]time.Time{}
+	}
+	h.reconciling[item] = start
+}
+
+// reconcileFinished records that the reconcile of the work item has returned
+func (h *health) reconcileFinished(item workItem) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.reconciling, item)
+}
+
+// addChild includes the health of a team controller in this health
+func (h *health) addChild(child *health) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.children == nil {
+		h.children = map[*health]bool{}
+	}
+	h.children[child] = true
+}
+
+// removeChild stops including the health of a team controller which has stopped
+func (h *health) removeChild(child *health) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.children, child)
+}
+
+// problems returns the watches which have been failing and the reconciles which have been running for longer than
+// the liveness timeout, including those of the children
+func (h *health) problems(now time.Time) []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	var answer []string
+	if h.livenessTimeout > 0 {
+		for resource, w := range h.watches {
+			if w.failing && now.Sub(w.lastSuccess) > h.livenessTimeout {
+				answer = append(answer, fmt.Sprintf("no successful list or watch of %s since %s", resource, w.lastSuccess.Format(time.RFC3339)))
+			}
+		}
+		for item, start := range h.reconciling {
+			if now.Sub(start) > h.livenessTimeout {
+				answer = append(answer, fmt.Sprintf("reconciling %s %s since %s", item.resource, item.key, start.Format(time.RFC3339)))
+			}
+		}
+	}
+	for child := range h.children {
+		answer = append(answer, child.problems(now)...)
+	}
+	sort.Strings(answer)
+	return answer
+}
+
+// isReady returns true if this and all the children are ready
+func (h *health) isReady() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if !h.ready {
+		return false
+	}
+	for child := range h.children {
+		if !child.isReady() {
+			return false
+		}
+	}
+	return true
+}
+
+// healthz fails if a watch has not managed to list or watch its resource, or a reconcile has been stuck, for longer
+// than the liveness timeout
+func (h *health) healthz(w http.ResponseWriter, _ *http.Request) {
+	if problems := h.problems(time.Now()); len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz fails until the informers have synced and the startup reconciliation has finished.
+// Replicas waiting for the leader election lease have nothing to sync so report ready
+func (h *health) readyz(w http.ResponseWriter, _ *http.Request) {
+	h.lock.RLock()
+	standby := h.standby
+	h.lock.RUnlock()
+	switch {
+	case standby:
+		fmt.Fprintln(w, "standby")
+	case h.isReady():
+		fmt.Fprintln(w, "ok")
+	default:
+		http.Error(w, "not synced", http.StatusServiceUnavailable)
+	}
+}
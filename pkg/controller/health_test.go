@@ -0,0 +1,145 @@
+package controller_test
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	jxfake "github.com/jenkins-x/jx-api/pkg/client/clientset/versioned/fake"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newHealthOptions returns the options of a controller propagating one Role and EnvironmentRoleBinding to staging
+func newHealthOptions(livenessTimeout time.Duration) *controller.RoleOptions {
+	o := &controller.RoleOptions{
+		ShutdownTimeout: 10 * time.Second,
+		LivenessTimeout: livenessTimeout,
+	}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+	return o
+}
+
+func Test_HealthEndpoints(t *testing.T) {
+	t.Parallel()
+	o := newHealthOptions(time.Minute)
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the controller should become ready")
+
+	code, body := o.Readyz()
+	assert.Equal(t, http.StatusOK, code, "readyz once synced: %s", body)
+	code, body = o.Healthz()
+	assert.Equal(t, http.StatusOK, code, "healthz while watching: %s", body)
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
+
+func Test_HealthzFailsWhenWatchesGoStale(t *testing.T) {
+	t.Parallel()
+	o := newHealthOptions(200 * time.Millisecond)
+	var failing int32 = 1
+	o.JxClient.(*jxfake.Clientset).PrependReactor("list", "environments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.LoadInt32(&failing) == 0 {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewServiceUnavailable("try again later")
+	})
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+
+	waitFor(t, func() bool {
+		code, body := o.Healthz()
+		return code == http.StatusInternalServerError && strings.Contains(body, "environments")
+	}, "healthz should fail once the environments have not been listed for longer than the liveness timeout")
+	code, _ := o.Readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code, "readyz before the watches have synced")
+
+	atomic.StoreInt32(&failing, 0)
+	waitFor(t, func() bool {
+		code, _ := o.Healthz()
+		return code == http.StatusOK
+	}, "healthz should recover once the environments are listed")
+	waitFor(t, o.IsReady, "the controller should become ready once the environments are listed")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
+
+func Test_HealthzFailsWhenReconcileIsStuck(t *testing.T) {
+	t.Parallel()
+	o := newHealthOptions(200 * time.Millisecond)
+	release := make(chan struct{})
+	o.KubeClient.(*fake.Clientset).PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return false, nil, nil
+	})
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+
+	waitFor(t, func() bool {
+		code, body := o.Healthz()
+		return code == http.StatusInternalServerError && strings.Contains(body, "reconciling")
+	}, "healthz should fail once a reconcile has been running for longer than the liveness timeout")
+
+	close(release)
+	waitFor(t, func() bool {
+		code, _ := o.Healthz()
+		return code == http.StatusOK
+	}, "healthz should recover once the reconcile returns")
+	waitFor(t, o.IsReady, "the controller should become ready once the reconcile returns")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionOptions configures the Lease based leader election used when running more than one replica
+type LeaderElectionOptions struct {
+	Enabled        bool
+	LeaseName      string
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+const (
+	leaderElectEnvVar    = "JX_CONTROLLER_LEADER_ELECT"
+	leaseNameEnvVar      = "JX_CONTROLLER_LEASE_NAME"
+	leaseNamespaceEnvVar = "JX_CONTROLLER_LEASE_NAMESPACE"
+	leaseDurationEnvVar  = "JX_CONTROLLER_LEASE_DURATION"
+	renewDeadlineEnvVar  = "JX_CONTROLLER_RENEW_DEADLINE"
+	retryPeriodEnvVar    = "JX_CONTROLLER_RETRY_PERIOD"
+
+	defaultLeaseName     = "jx-role-controller"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// DefaultLeaderElectionOptions returns the leader election options with the default lease name and durations
+func DefaultLeaderElectionOptions() LeaderElectionOptions {
+	return LeaderElectionOptions{
+		LeaseName:     defaultLeaseName,
+		LeaseDuration: defaultLeaseDuration,
+		RenewDeadline: defaultRenewDeadline,
+		RetryPeriod:   defaultRetryPeriod,
+	}
+}
+
+// loadEnv overrides the options from any leader election environment variables
+func (l *LeaderElectionOptions) loadEnv() error {
+	if os.Getenv(leaderElectEnvVar) != "" {
+		l.Enabled = util.EnvVarBoolean(os.Getenv(leaderElectEnvVar))
+	}
+	if os.Getenv(leaseNameEnvVar) != "" {
+		l.LeaseName = os.Getenv(leaseNameEnvVar)
+	}
+	if os.Getenv(leaseNamespaceEnvVar) != "" {
+		l.LeaseNamespace = os.Getenv(leaseNamespaceEnvVar)
+	}
+	return util.CombineErrors(
+		durationEnvVar(leaseDurationEnvVar, &l.LeaseDuration),
+		durationEnvVar(renewDeadlineEnvVar, &l.RenewDeadline),
+		durationEnvVar(retryPeriodEnvVar, &l.RetryPeriod),
+	)
+}
+
+// runWithLeaderElection blocks until this replica holds the lease, then runs the controller until the lease is lost
+// or the context is cancelled
+func (o *RoleOptions) runWithLeaderElection(ctx context.Context) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "getting hostname for the leader election identity")
+	}
+	identity = identity + "_" + string(uuid.NewUUID())
+
+	leaseNamespace := o.LeaderElection.LeaseNamespace
+	if leaseNamespace == "" {
+		leaseNamespace = o.TeamNs
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, leaseNamespace, o.LeaderElection.LeaseName,
+		o.KubeClient.CoreV1(), o.KubeClient.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return errors.Wrap(err, "creating leader election lock")
+	}
+
+	// the elector releases the lease as soon as its context is cancelled, so we only cancel it once the controller
+	// has drained its in-flight reconciles or if we are shut down before ever leading
+	electorCtx, cancelElector := context.WithCancel(context.Background())
+	defer cancelElector()
+	leading := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-leading:
+			default:
+				cancelElector()
+			}
+		case <-electorCtx.Done():
+		}
+	}()
+
+	runErrs := make(chan error, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            &serialLock{Interface: lock},
+		LeaseDuration:   o.LeaderElection.LeaseDuration,
+		RenewDeadline:   o.LeaderElection.RenewDeadline,
+		RetryPeriod:     o.LeaderElection.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            o.LeaderElection.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.Logger().Infof("%s acquired lease %s/%s", identity, leaseNamespace, o.LeaderElection.LeaseName)
+				close(leading)
+				o.health.setStandby(false)
+				runCtx, cancelRun := context.WithCancel(leaderCtx)
+				defer cancelRun()
+				go func() {
+					select {
+					case <-ctx.Done():
+						cancelRun()
+					case <-runCtx.Done():
+					}
+				}()
+				runErrs <- o.run(runCtx)
+				cancelElector()
+			},
+			OnStoppedLeading: func() {
+				o.health.setReady(false)
+				log.Logger().Infof("%s stopped leading lease %s/%s", identity, leaseNamespace, o.LeaderElection.LeaseName)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Logger().Infof("waiting for lease %s/%s currently held by %s", leaseNamespace, o.LeaderElection.LeaseName, leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "creating leader elector")
+	}
+	o.health.setStandby(true)
+	elector.Run(electorCtx)
+
+	select {
+	case <-leading:
+		err = <-runErrs
+	default:
+		// we were shut down before acquiring the lease
+		return nil
+	}
+	if err != nil || ctx.Err() != nil {
+		return err
+	}
+	// exit so that we restart as a candidate and another replica can take over in the meantime
+	return errors.Errorf("lost lease %s/%s", leaseNamespace, o.LeaderElection.LeaseName)
+}
+
+// serialLock serialises the calls to the lease lock. When a renew times out the elector still has the attempt running
+// in the background while it releases the lease, and the lease lock is not safe for concurrent use
+type serialLock struct {
+	resourcelock.Interface
+	lock sync.Mutex
+}
+
+// Get returns the leader election record of the lease
+func (l *serialLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Interface.Get()
+}
+
+// Create creates the lease with the leader election record
+func (l *serialLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Interface.Create(ler)
+}
+
+// Update updates the lease with the leader election record
+func (l *serialLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Interface.Update(ler)
+}
+
+// RecordEvent records an event against the lease
+func (l *serialLock) RecordEvent(s string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.Interface.RecordEvent(s)
+}
@@ -0,0 +1,109 @@
+package controller_test
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newLeaderElectionOptions() controller.LeaderElectionOptions {
+	return controller.LeaderElectionOptions{
+		Enabled:       true,
+		LeaseName:     "jx-role-controller",
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   50 * time.Millisecond,
+	}
+}
+
+// leaseHolder returns the identity of the replica holding the lease, or an empty string if nobody does
+func leaseHolder(o *controller.RoleOptions) string {
+	lease, err := o.KubeClient.CoordinationV1().Leases("jx").Get("jx-role-controller", metav1.GetOptions{})
+	if err != nil || lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func Test_LeaderElectionHandOver(t *testing.T) {
+	t.Parallel()
+	first := &controller.RoleOptions{
+		ShutdownTimeout: 10 * time.Second,
+		LeaderElection:  newLeaderElectionOptions(),
+	}
+	testhelpers.ConfigureTestOptionsWithResources(first, nil, []runtime.Object{kube.NewPermanentEnvironment("staging")})
+	second := &controller.RoleOptions{
+		JxClient:        first.JxClient,
+		KubeClient:      first.KubeClient,
+		TeamNs:          first.TeamNs,
+		ShutdownTimeout: 10 * time.Second,
+		LeaderElection:  newLeaderElectionOptions(),
+	}
+
+	cancelFirst, firstErrs := runInBackground(first)
+	defer cancelFirst()
+	waitFor(t, first.IsReady, "the first replica should lead and become ready")
+	firstHolder := leaseHolder(first)
+	require.NotEmpty(t, firstHolder, "the first replica should hold the lease")
+
+	cancelSecond, secondErrs := runInBackground(second)
+	defer cancelSecond()
+	waitFor(t, func() bool {
+		_, body := second.Readyz()
+		return strings.Contains(body, "standby")
+	}, "the second replica should wait on standby")
+	code, _ := second.Readyz()
+	assert.Equal(t, http.StatusOK, code, "a replica on standby should report ready")
+	assert.False(t, second.IsReady(), "a replica on standby should not reconcile")
+	assert.Equal(t, firstHolder, leaseHolder(first), "the first replica should keep the lease")
+
+	// shutting down the leader releases the lease so the standby replica takes over
+	cancelFirst()
+	assert.NoError(t, waitForRun(t, firstErrs), "a replica shut down while leading should stop cleanly")
+	waitFor(t, second.IsReady, "the second replica should take over the lease and become ready")
+	assert.NotEqual(t, firstHolder, leaseHolder(second), "the second replica should hold the lease")
+
+	cancelSecond()
+	assert.NoError(t, waitForRun(t, secondErrs))
+}
+
+func Test_LeaderElectionLostLease(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		ShutdownTimeout: 10 * time.Second,
+		LeaderElection:  newLeaderElectionOptions(),
+	}
+	testhelpers.ConfigureTestOptionsWithResources(o, nil, []runtime.Object{kube.NewPermanentEnvironment("staging")})
+	// the fake client does not support adding reactors while it is in use
+	var unreachable int32
+	o.KubeClient.(*fake.Clientset).PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.LoadInt32(&unreachable) == 0 {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewServiceUnavailable("unreachable")
+	})
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the replica should lead and become ready")
+
+	// the lease can no longer be renewed, e.g. as the API server is unreachable
+	atomic.StoreInt32(&unreachable, 1)
+	err := waitForRun(t, errs)
+	require.Error(t, err, "a replica losing the lease should exit so that it restarts as a candidate")
+	assert.Contains(t, err.Error(), "lost lease jx/jx-role-controller")
+	assert.False(t, o.IsReady(), "a replica which lost the lease should not report ready")
+}
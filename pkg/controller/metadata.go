@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetadataOptions selects the labels and annotations of the team Roles and EnvironmentRoleBindings which are copied
+// onto the Roles and RoleBindings propagated into the environment namespaces
+type MetadataOptions struct {
+	// AllowPrefixes are the prefixes of the label and annotation keys which are copied, nothing is copied if empty
+	AllowPrefixes []string
+	// DenyPrefixes are the prefixes of the keys which are never copied, even if they are allowed
+	DenyPrefixes []string
+}
+
+const (
+	metadataAllowPrefixesEnvVar = "JX_CONTROLLER_METADATA_ALLOW_PREFIXES"
+	metadataDenyPrefixesEnvVar  = "JX_CONTROLLER_METADATA_DENY_PREFIXES"
+)
+
+// reservedMetadataPrefixes are never copied as the controller manages them or they only make sense on the source
+var reservedMetadataPrefixes = []string{
+	kube.LabelCreatedBy,
+	kube.LabelTeam,
+	kube.LabelKind,
+	kube.AnnotationSyncStatus,
+	kube.AnnotationAggregateClusterRoles,
+	kube.AnnotationEnvironmentSelector,
+	kube.AnnotationAdoptTeam,
+	kube.AnnotationSourceNamespace,
+	kube.AnnotationSourceKind,
+	kube.AnnotationSourceName,
+	kube.AnnotationSourceUID,
+	kube.AnnotationManagedMetadata,
+	"kubectl.kubernetes.io/",
+}
+
+// loadEnv configures the metadata propagation from the comma separated environment variables
+func (m *MetadataOptions) loadEnv() {
+	if value, ok := os.LookupEnv(metadataAllowPrefixesEnvVar); ok {
+		m.AllowPrefixes = splitList(value)
+	}
+	if value, ok := os.LookupEnv(metadataDenyPrefixesEnvVar); ok {
+		m.DenyPrefixes = splitList(value)
+	}
+}
+
+// splitList splits the comma separated list, ignoring blank entries
+func splitList(text string) []string {
+	var answer []string
+	for _, value := range strings.Split(text, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			answer = append(answer, value)
+		}
+	}
+	return answer
+}
+
+// propagates returns true if the label or annotation key is copied onto the propagated objects
+func (m *MetadataOptions) propagates(key string) bool {
+	return hasAnyPrefix(key, m.AllowPrefixes) && !hasAnyPrefix(key, m.DenyPrefixes) && !hasAnyPrefix(key, reservedMetadataPrefixes)
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// propagated returns the entries of the labels or annotations which are copied onto the propagated objects
+func (m *MetadataOptions) propagated(values map[string]string) map[string]string {
+	answer := map[string]string{}
+	for key, value := range values {
+		if m.propagates(key) {
+			answer[key] = value
+		}
+	}
+	return answer
+}
+
+// propagatedMetadata returns the labels and annotations of an object propagated from the source of the given kind:
+// the allowed labels and annotations of the source, the ownership labels of the team, the annotations referring
+// back to the source and the annotation recording which of them the controller manages
+func (o *RoleOptions) propagatedMetadata(kind string, source metav1.Object, ownerReferences []metav1.OwnerReference) (map[string]string, map[string]string) {
+	labels := o.Metadata.propagated(source.GetLabels())
+	labels[kube.LabelCreatedBy] = kube.ValueCreatedByJX
+	labels[kube.LabelTeam] = o.TeamNs
+
+	annotations := o.Metadata.propagated(source.GetAnnotations())
+	annotations[kube.AnnotationSourceNamespace] = source.GetNamespace()
+	annotations[kube.AnnotationSourceKind] = kind
+	annotations[kube.AnnotationSourceName] = source.GetName()
+	if uid := source.GetUID(); uid != "" {
+		annotations[kube.AnnotationSourceUID] = string(uid)
+	}
+	annotations[kube.AnnotationManagedMetadata] = newManagedMetadata(labels, annotations, ownerReferences).String()
+	return labels, annotations
+}
+
+// managedMetadata records the labels, annotations and owner references the controller manages on a propagated object
+// so that it can remove them again once they are no longer propagated, without touching any others
+type managedMetadata struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+	// OwnerReferences are the UIDs of the owners
+	OwnerReferences []string `json:"ownerReferences,omitempty"`
+}
+
+func newManagedMetadata(labels, annotations map[string]string, ownerReferences []metav1.OwnerReference) managedMetadata {
+	answer := managedMetadata{}
+	for key := range labels {
+		answer.Labels = append(answer.Labels, key)
+	}
+	for key := range annotations {
+		if key != kube.AnnotationManagedMetadata {
+			answer.Annotations = append(answer.Annotations, key)
+		}
+	}
+	for _, ref := range ownerReferences {
+		answer.OwnerReferences = append(answer.OwnerReferences, string(ref.UID))
+	}
+	sort.Strings(answer.Labels)
+	sort.Strings(answer.Annotations)
+	sort.Strings(answer.OwnerReferences)
+	return answer
+}
+
+// managedMetadataOf returns the metadata the controller recorded as managing on the object, which is empty for
+// objects created before it was recorded
+func managedMetadataOf(obj metav1.Object) managedMetadata {
+	answer := managedMetadata{}
+	text := obj.GetAnnotations()[kube.AnnotationManagedMetadata]
+	if text == "" {
+		return answer
+	}
+	err := json.Unmarshal([]byte(text), &answer)
+	if err != nil {
+		log.Logger().Warnf("ignoring invalid %s annotation on %s in namespace %s: %s", kube.AnnotationManagedMetadata, obj.GetName(), obj.GetNamespace(), err)
+	}
+	return answer
+}
+
+func (m managedMetadata) String() string {
+	data, err := json.Marshal(m)
+	if err != nil {
+		// cannot happen for a struct of strings
+		return ""
+	}
+	return string(data)
+}
+
+// reconcileMetadata updates the labels, annotations and owner references of the existing object which the controller
+// manages to those of the desired object, removing the ones it no longer propagates and leaving any others alone.
+// Returns true if the existing object was changed
+func reconcileMetadata(existing, desired metav1.Object) bool {
+	previous := managedMetadataOf(existing)
+
+	labels, labelsChanged := reconcileMap(existing.GetLabels(), desired.GetLabels(), previous.Labels)
+	annotations, annotationsChanged := reconcileMap(existing.GetAnnotations(), desired.GetAnnotations(), previous.Annotations)
+	ownerReferences, ownerReferencesChanged := reconcileOwnerReferences(existing.GetOwnerReferences(), desired.GetOwnerReferences(), previous.OwnerReferences)
+	if labelsChanged {
+		existing.SetLabels(labels)
+	}
+	if annotationsChanged {
+		existing.SetAnnotations(annotations)
+	}
+	if ownerReferencesChanged {
+		existing.SetOwnerReferences(ownerReferences)
+	}
+	return labelsChanged || annotationsChanged || ownerReferencesChanged
+}
+
+// reconcileMap returns the current entries with the desired ones set and the previously managed ones which are no
+// longer desired removed
+func reconcileMap(current, desired map[string]string, previouslyManaged []string) (map[string]string, bool) {
+	answer := map[string]string{}
+	for key, value := range current {
+		answer[key] = value
+	}
+	changed := false
+	for _, key := range previouslyManaged {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if _, ok := answer[key]; ok {
+			delete(answer, key)
+			changed = true
+		}
+	}
+	for key, value := range desired {
+		if existing, ok := answer[key]; !ok || existing != value {
+			answer[key] = value
+			changed = true
+		}
+	}
+	return answer, changed
+}
+
+// reconcileOwnerReferences returns the current owner references with the desired ones set and the previously managed
+// ones which are no longer desired removed
+func reconcileOwnerReferences(current, desired []metav1.OwnerReference, previouslyManaged []string) ([]metav1.OwnerReference, bool) {
+	desiredByUID := map[string]metav1.OwnerReference{}
+	for _, ref := range desired {
+		desiredByUID[string(ref.UID)] = ref
+	}
+	var answer []metav1.OwnerReference
+	changed := false
+	for _, ref := range current {
+		uid := string(ref.UID)
+		if want, ok := desiredByUID[uid]; ok {
+			if !reflect.DeepEqual(ref, want) {
+				ref = want
+				changed = true
+			}
+			delete(desiredByUID, uid)
+		} else if util.StringArrayIndex(previouslyManaged, uid) >= 0 {
+			changed = true
+			continue
+		}
+		answer = append(answer, ref)
+	}
+	for _, ref := range desired {
+		if _, ok := desiredByUID[string(ref.UID)]; ok {
+			answer = append(answer, ref)
+			changed = true
+		}
+	}
+	return answer, changed
+}
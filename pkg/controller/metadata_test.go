@@ -0,0 +1,127 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_MetadataPropagation(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+		Metadata: controller.MetadataOptions{
+			AllowPrefixes: []string{"cost-centre", "example.com/", kube.LabelTeam},
+			DenyPrefixes:  []string{"example.com/secret"},
+		},
+	}
+	teamNs := "jx"
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: teamNs,
+			UID:       "role-uid",
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+				"cost-centre":  "cc-1",
+				"unrelated":    "value",
+				// reserved for the controller even though it is allowed
+				kube.LabelTeam: "not-the-team",
+			},
+			Annotations: map[string]string{
+				"example.com/owner":                                "alice",
+				"example.com/secret-token":                         "hidden",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"get", "watch", "list"},
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+			},
+		},
+	}
+	binding := &v1.EnvironmentRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mybinding",
+			Namespace: teamNs,
+			UID:       "binding-uid",
+			Labels: map[string]string{
+				"cost-centre": "cc-2",
+			},
+			Annotations: map[string]string{
+				"example.com/audit-ticket": "SEC-42",
+			},
+		},
+		Spec: v1.EnvironmentRoleBindingSpec{
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      "jenkins",
+					Namespace: teamNs,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     "myrole",
+			},
+		},
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{role},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			binding,
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	r, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      teamNs,
+		"cost-centre":       "cc-1",
+	}, r.Labels, "labels of the propagated Role")
+	assert.Equal(t, map[string]string{
+		"example.com/owner":            "alice",
+		kube.AnnotationSourceNamespace: teamNs,
+		kube.AnnotationSourceKind:      "Role",
+		kube.AnnotationSourceName:      "myrole",
+		kube.AnnotationSourceUID:       "role-uid",
+		kube.AnnotationManagedMetadata: `{"labels":["cost-centre","jenkins.io/created-by","team"],` +
+			`"annotations":["example.com/owner","jenkins.io/source-kind","jenkins.io/source-name","jenkins.io/source-namespace","jenkins.io/source-uid"]}`,
+	}, r.Annotations, "annotations of the propagated Role")
+
+	rb, err := o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      teamNs,
+		"cost-centre":       "cc-2",
+	}, rb.Labels, "labels of the propagated RoleBinding")
+	assert.Equal(t, map[string]string{
+		"example.com/audit-ticket":     "SEC-42",
+		kube.AnnotationSourceNamespace: teamNs,
+		kube.AnnotationSourceKind:      "EnvironmentRoleBinding",
+		kube.AnnotationSourceName:      "mybinding",
+		kube.AnnotationSourceUID:       "binding-uid",
+		kube.AnnotationManagedMetadata: `{"labels":["cost-centre","jenkins.io/created-by","team"],` +
+			`"annotations":["example.com/audit-ticket","jenkins.io/source-kind","jenkins.io/source-name","jenkins.io/source-namespace","jenkins.io/source-uid"]}`,
+	}, rb.Annotations, "annotations of the propagated RoleBinding")
+}
@@ -0,0 +1,112 @@
+package controller_test
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// hasTeamMetric returns true if the metric has a series for the team
+func hasTeamMetric(t *testing.T, name, team string) bool {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "team" && label.GetValue() == team {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func Test_Metrics(t *testing.T) {
+	t.Parallel()
+	// the metrics are global so this test uses a team of its own
+	teamNs := "jx-metrics"
+	o := &controller.RoleOptions{
+		TeamNamespaces:  []string{teamNs},
+		PruneInterval:   20 * time.Millisecond,
+		ShutdownTimeout: 10 * time.Second,
+	}
+	env := kube.NewPermanentEnvironment("staging")
+	env.Namespace = teamNs
+	env.Spec.Namespace = teamNs + "-staging"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			env,
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+	start := float64(time.Now().UnixNano()) / float64(time.Second)
+	lastFullSync := metrics.LastFullSync.WithLabelValues(teamNs)
+	queueDepth := metrics.QueueDepth.WithLabelValues(teamNs)
+	creates := metrics.Writes.WithLabelValues("RoleBinding", "create", teamNs+"-staging")
+	createsBefore := testutil.ToFloat64(creates)
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the team should become ready")
+
+	synced := testutil.ToFloat64(lastFullSync)
+	assert.True(t, synced >= start, "the startup reconcile of the team should count as a full sync")
+	assert.Equal(t, float64(1), testutil.ToFloat64(creates)-createsBefore, "RoleBinding creates in the environment namespace")
+	waitFor(t, func() bool {
+		return testutil.ToFloat64(queueDepth) == 0
+	}, "the queue of the team should be drained")
+	waitFor(t, func() bool {
+		return testutil.ToFloat64(lastFullSync) > synced
+	}, "the prune passes should keep recording full syncs while watching")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+	assert.False(t, hasTeamMetric(t, "jx_role_controller_queue_depth", teamNs), "the queue depth of a stopped team should be removed")
+	assert.False(t, hasTeamMetric(t, "jx_role_controller_last_full_sync_timestamp_seconds", teamNs), "the last full sync of a stopped team should be removed")
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ownershipConflict is returned instead of modifying a Role or RoleBinding in an environment namespace which is owned
+// by another team or was not created by the controller at all. It is reported rather than retried as only adopting
+// the object resolves it
+type ownershipConflict struct {
+	kind      string
+	namespace string
+	name      string
+	// owner is the team owning the object, blank if it was not created by the controller
+	owner string
+}
+
+func (e *ownershipConflict) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("%s %s in namespace %s was not created by Jenkins X", e.kind, e.name, e.namespace)
+	}
+	return fmt.Sprintf("%s %s in namespace %s is owned by team %s", e.kind, e.name, e.namespace, e.owner)
+}
+
+// isOwnershipConflict returns true if the error is an ownershipConflict
+func isOwnershipConflict(err error) bool {
+	_, ok := errors.Cause(err).(*ownershipConflict)
+	return ok
+}
+
+// checkOwnership returns an ownershipConflict if the existing object is owned by another team or was not created by
+// the controller, reporting it against the sources. Returns true if the object is to be adopted by this team, either
+// as it is annotated to be or as it was not created by the controller and AdoptForeign is set
+func (o *RoleOptions) checkOwnership(kind string, obj metav1.Object, sources ...runtime.Object) (bool, error) {
+	if obj.GetAnnotations()[kube.AnnotationAdoptTeam] == o.TeamNs {
+		log.Logger().Infof("Adopting %s %s in namespace %s for team %s", kind, obj.GetName(), obj.GetNamespace(), o.TeamNs)
+		return true, nil
+	}
+	labels := obj.GetLabels()
+	reason := reasonOwnershipConflict
+	owner := kube.OwningTeam(labels)
+	if labels[kube.LabelCreatedBy] != kube.ValueCreatedByJX {
+		if o.AdoptForeign {
+			log.Logger().Infof("Adopting %s %s in namespace %s which was not created by Jenkins X for team %s", kind, obj.GetName(), obj.GetNamespace(), o.TeamNs)
+			return true, nil
+		}
+		reason = reasonForeignObject
+	} else if owner == "" || owner == o.TeamNs {
+		// objects created by older versions of the controller have no team label
+		return false, nil
+	}
+	conflict := &ownershipConflict{kind: kind, namespace: obj.GetNamespace(), name: obj.GetName(), owner: owner}
+	log.Logger().Warnf("%s so it was not modified", conflict)
+	metrics.RecordOwnershipConflict(kind, obj.GetNamespace())
+	for _, source := range sources {
+		o.event(source, corev1.EventTypeWarning, reason, "%s so it was not modified, annotate it with %s=%s to adopt it",
+			conflict, kube.AnnotationAdoptTeam, o.TeamNs)
+	}
+	return false, conflict
+}
+
+// adopt labels the object as owned by this team and removes the adopt annotation
+func (o *RoleOptions) adopt(obj metav1.Object) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[kube.LabelCreatedBy] = kube.ValueCreatedByJX
+	labels[kube.LabelTeam] = o.TeamNs
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	delete(annotations, kube.AnnotationAdoptTeam)
+	obj.SetAnnotations(annotations)
+}
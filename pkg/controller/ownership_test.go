@@ -0,0 +1,251 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_OwnershipConflict(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:  true,
+		Recorder: recorder,
+	}
+	teamNs := "jx"
+	ns := "jx-owned"
+	otherLabels := map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      "other",
+	}
+	rules := []rbacv1.PolicyRule{
+		{
+			Verbs:     []string{"get", "watch", "list"},
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+		},
+	}
+	otherRules := []rbacv1.PolicyRule{
+		{
+			Verbs:     []string{"*"},
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+		},
+	}
+	newRole := func(name string) *rbacv1.Role {
+		return &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+				Labels: map[string]string{
+					kube.LabelKind: kube.ValueKindEnvironmentRole,
+				},
+			},
+			Rules: rules,
+		}
+	}
+	newBinding := func(name, roleName string) *v1.EnvironmentRoleBinding {
+		return &v1.EnvironmentRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+			},
+			Spec: v1.EnvironmentRoleBindingSpec{
+				Subjects: []rbacv1.Subject{
+					{
+						Kind:      "ServiceAccount",
+						Name:      "jenkins",
+						Namespace: teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "Role",
+					Name:     roleName,
+				},
+			},
+		}
+	}
+	otherSubjects := []rbacv1.Subject{
+		{
+			Kind:      "ServiceAccount",
+			Name:      "jenkins",
+			Namespace: "other",
+		},
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			newRole("shared"),
+			newRole("adopted"),
+			// written into the same namespace by the controller of the team other
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: ns, Labels: otherLabels},
+				Rules:      otherRules,
+			},
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: ns, Labels: otherLabels},
+				RoleRef:    newBinding("shared", "shared").Spec.RoleRef,
+				Subjects:   otherSubjects,
+			},
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "adopted",
+					Namespace:   ns,
+					Labels:      otherLabels,
+					Annotations: map[string]string{kube.AnnotationAdoptTeam: teamNs},
+				},
+				Rules: otherRules,
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("owned"),
+			newBinding("shared", "shared"),
+			newBinding("adopted", "adopted"),
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err, "ownership conflicts should be reported rather than fail the sync")
+
+	role, err := o.KubeClient.RbacV1().Roles(ns).Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, otherRules, role.Rules, "the Role of the other team should not be modified")
+	assert.Equal(t, "other", role.Labels[kube.LabelTeam])
+
+	roleBinding, err := o.KubeClient.RbacV1().RoleBindings(ns).Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, otherSubjects, roleBinding.Subjects, "the RoleBinding of the other team should not be modified")
+
+	role, err = o.KubeClient.RbacV1().Roles(ns).Get("adopted", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, rules, role.Rules, "the adopted Role should be updated")
+	assert.Equal(t, teamNs, role.Labels[kube.LabelTeam], "the adopted Role should be labelled with the team")
+	assert.NotContains(t, role.Annotations, kube.AnnotationAdoptTeam, "the adopt annotation should be removed")
+
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	status, err := controller.GetSyncStatus(binding)
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	found := false
+	for _, namespaceStatus := range status.Namespaces {
+		if namespaceStatus.Namespace == ns {
+			found = true
+			assert.False(t, namespaceStatus.RoleInSync)
+			assert.False(t, namespaceStatus.RoleBindingInSync)
+			assert.Contains(t, namespaceStatus.LastError, "is owned by team other")
+		}
+	}
+	assert.True(t, found, "status of namespace %s", ns)
+
+	assertEvents(t, drainEvents(recorder),
+		"Warning OwnershipConflict Role shared in namespace jx-owned is owned by team other so it was not modified, annotate it with jenkins.io/adopt-team=jx to adopt it",
+		"Warning OwnershipConflict RoleBinding shared in namespace jx-owned is owned by team other so it was not modified",
+		"Normal RoleUpdated Updated Role adopted in namespace jx-owned",
+	)
+	assert.True(t, testutil.ToFloat64(metrics.OwnershipConflicts.WithLabelValues("Role", ns)) > 0, "ownership conflicts metric for Roles")
+	assert.True(t, testutil.ToFloat64(metrics.OwnershipConflicts.WithLabelValues("RoleBinding", ns)) > 0, "ownership conflicts metric for RoleBindings")
+}
+
+func Test_ForeignObjects(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:  true,
+		Recorder: recorder,
+	}
+	teamNs := "jx"
+	ns := "jx-foreign"
+	rules := []rbacv1.PolicyRule{
+		{
+			Verbs:     []string{"get", "watch", "list"},
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+		},
+	}
+	handmadeRules := []rbacv1.PolicyRule{
+		{
+			Verbs:     []string{"get"},
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+		},
+	}
+	newRole := func(name string) *rbacv1.Role {
+		return &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+				Labels: map[string]string{
+					kube.LabelKind: kube.ValueKindEnvironmentRole,
+				},
+			},
+			Rules: rules,
+		}
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			newRole("handmade"),
+			newRole("legacy"),
+			// created by an admin with the same name as the team Role
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "handmade", Namespace: ns, Labels: map[string]string{"owner": "admin"}},
+				Rules:      handmadeRules,
+			},
+			// created by an older version of the controller which did not label the team
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "legacy",
+					Namespace: ns,
+					Labels:    map[string]string{kube.LabelCreatedBy: kube.ValueCreatedByJX},
+				},
+				Rules: handmadeRules,
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("foreign"),
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	role, err := o.KubeClient.RbacV1().Roles(ns).Get("handmade", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, handmadeRules, role.Rules, "the hand made Role should not be modified")
+	assert.NotContains(t, role.Labels, kube.LabelCreatedBy)
+
+	role, err = o.KubeClient.RbacV1().Roles(ns).Get("legacy", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, rules, role.Rules, "the Role created by an older version should be updated")
+
+	assertEvents(t, drainEvents(recorder),
+		"Warning ForeignObject Role handmade in namespace jx-foreign was not created by Jenkins X so it was not modified, annotate it with jenkins.io/adopt-team=jx to adopt it",
+	)
+
+	// lets opt in to adopting it
+	o.AdoptForeign = true
+	err = o.Run(context.Background())
+	require.NoError(t, err)
+
+	role, err = o.KubeClient.RbacV1().Roles(ns).Get("handmade", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, rules, role.Rules, "the adopted Role should be updated")
+	assert.Equal(t, kube.ValueCreatedByJX, role.Labels[kube.LabelCreatedBy], "the adopted Role should be labelled as created by Jenkins X")
+	assert.Equal(t, teamNs, role.Labels[kube.LabelTeam], "the adopted Role should be labelled with the team")
+	assert.Equal(t, "admin", role.Labels["owner"], "the other labels of the adopted Role should be kept")
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	kindEnvironmentRoleBinding = "EnvironmentRoleBinding"
+
+	// operationRecreate is shown in the plan when an object has to be deleted and created again
+	operationRecreate = "recreate"
+
+	// PlanFormatTable prints the dry-run plan as a human readable table
+	PlanFormatTable = "table"
+	// PlanFormatJSON prints the dry-run plan as JSON
+	PlanFormatJSON = "json"
+	// PlanFormatYAML prints the dry-run plan as YAML
+	PlanFormatYAML = "yaml"
+)
+
+// Change is a create, update or delete the controller would have made if it was not running in dry-run mode
+type Change struct {
+	Operation string `json:"operation"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Details   string `json:"details,omitempty"`
+}
+
+// plan collects the changes computed in dry-run mode
+type plan struct {
+	lock    sync.Mutex
+	changes map[string]Change
+}
+
+// add records the change, replacing any earlier change to the same object
+func (p *plan) add(change Change) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.changes == nil {
+		p.changes = map[string]Change{}
+	}
+	key := strings.Join([]string{change.Kind, change.Namespace, change.Name}, "/")
+	if earlier, ok := p.changes[key]; ok {
+		switch {
+		case earlier.Operation == operationCreate && change.Operation == operationUpdate:
+			// the object does not exist yet so the update is part of the create
+			change.Operation = operationCreate
+		case earlier.Operation == operationCreate && change.Operation == operationDelete:
+			// a temporary object which is never left behind
+			delete(p.changes, key)
+			return
+		case earlier.Operation == operationDelete && change.Operation == operationCreate:
+			change.Operation = operationRecreate
+		}
+	}
+	p.changes[key] = change
+}
+
+// reset forgets the changes of an earlier dry run
+func (p *plan) reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.changes = nil
+}
+
+// list returns the changes sorted by namespace, kind and name
+func (p *plan) list() []Change {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	answer := make([]Change, 0, len(p.changes))
+	for _, change := range p.changes {
+		answer = append(answer, change)
+	}
+	sort.Slice(answer, func(i, j int) bool {
+		a, b := answer[i], answer[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return answer
+}
+
+// Changes returns the changes computed by the last dry run
+func (o *RoleOptions) Changes() []Change {
+	return o.plan.list()
+}
+
+// WritePlan writes the changes computed by the last dry run to the writer in the given format
+func (o *RoleOptions) WritePlan(out io.Writer, format string) error {
+	changes := o.Changes()
+	switch format {
+	case "", PlanFormatTable:
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "OPERATION\tKIND\tNAMESPACE\tNAME\tDETAILS")
+		for _, change := range changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", change.Operation, change.Kind, change.Namespace, change.Name, change.Details)
+		}
+		return w.Flush()
+	case PlanFormatJSON:
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshalling plan to JSON")
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case PlanFormatYAML:
+		data, err := yaml.Marshal(changes)
+		if err != nil {
+			return errors.Wrap(err, "marshalling plan to YAML")
+		}
+		_, err = out.Write(data)
+		return err
+	default:
+		return errors.Errorf("unknown plan format %q, expecting one of %s, %s or %s", format, PlanFormatTable, PlanFormatJSON, PlanFormatYAML)
+	}
+}
+
+// describeRules summarises the rules of a Role for the plan
+func describeRules(rules []rbacv1.PolicyRule) string {
+	var parts []string
+	for _, rule := range rules {
+		resources := append(append([]string{}, rule.Resources...), rule.NonResourceURLs...)
+		parts = append(parts, fmt.Sprintf("%s on %s", strings.Join(rule.Verbs, ","), strings.Join(resources, ",")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// describeBinding summarises the role and subjects of a RoleBinding for the plan
+func describeBinding(roleRef rbacv1.RoleRef, subjects []rbacv1.Subject) string {
+	var names []string
+	for _, subject := range subjects {
+		name := subject.Name
+		if subject.Namespace != "" {
+			name = subject.Namespace + "/" + name
+		}
+		names = append(names, subject.Kind+" "+name)
+	}
+	return fmt.Sprintf("%s %s to %s", roleRef.Kind, roleRef.Name, strings.Join(names, ", "))
+}
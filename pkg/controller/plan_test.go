@@ -0,0 +1,147 @@
+package controller_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_DryRun(t *testing.T) {
+	t.Parallel()
+	out := &bytes.Buffer{}
+	o := &controller.RoleOptions{
+		DryRun:        true,
+		PlanFormat:    controller.PlanFormatJSON,
+		Out:           out,
+		PruneInterval: time.Minute,
+	}
+	teamNs := "jx"
+	roleRef := rbacv1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "Role",
+		Name:     "myrole",
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"get", "watch", "list"},
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "pods"},
+			},
+		},
+	}
+	envRoleBinding := &v1.EnvironmentRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mybinding",
+			Namespace: teamNs,
+		},
+		Spec: v1.EnvironmentRoleBindingSpec{
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      "jenkins",
+					Namespace: teamNs,
+				},
+			},
+			RoleRef: roleRef,
+			Environments: []v1.EnvironmentFilter{
+				{
+					Includes: []string{"staging"},
+				},
+			},
+		},
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			role,
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "stale",
+					Namespace: "jx-staging",
+					Labels: map[string]string{
+						kube.LabelCreatedBy: kube.ValueCreatedByJX,
+						kube.LabelTeam:      teamNs,
+					},
+				},
+				RoleRef: roleRef,
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			envRoleBinding,
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	_, err = o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "dry run should not create Role myrole")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "dry run should not create RoleBinding mybinding")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("stale", metav1.GetOptions{})
+	assert.NoError(t, err, "dry run should not delete RoleBinding stale")
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, binding.Finalizers, "dry run should not add finalizers")
+
+	expected := []controller.Change{
+		{
+			Operation: "create",
+			Kind:      "Role",
+			Namespace: "jx-staging",
+			Name:      "myrole",
+			Details:   "get,watch,list on configmaps,pods",
+		},
+		{
+			Operation: "create",
+			Kind:      "RoleBinding",
+			Namespace: "jx-staging",
+			Name:      "mybinding",
+			Details:   "Role myrole to ServiceAccount jx/jenkins",
+		},
+		{
+			Operation: "delete",
+			Kind:      "RoleBinding",
+			Namespace: "jx-staging",
+			Name:      "stale",
+		},
+	}
+	assert.Equal(t, expected, o.Changes())
+
+	var printed []controller.Change
+	err = json.Unmarshal(out.Bytes(), &printed)
+	require.NoError(t, err, "parsing plan %s", out.String())
+	assert.Equal(t, expected, printed)
+
+	table := &bytes.Buffer{}
+	err = o.WritePlan(table, controller.PlanFormatTable)
+	require.NoError(t, err)
+	assert.Contains(t, table.String(), "OPERATION")
+	assert.Contains(t, table.String(), "mybinding")
+
+	err = o.WritePlan(table, "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"strings"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Prune deletes the Roles and RoleBindings created by the controller for this team in any namespace which are no
+// longer backed by an EnvironmentRole, EnvironmentRoleBinding or Environment.
+// This recovers from delete events we missed while the controller was not running
+func (o *RoleOptions) Prune() error {
+	log.Logger().Info("pruning orphaned roles and role bindings")
+	roleList, err := o.KubeClient.RbacV1().Roles(o.TeamNs).List(o.listOptions(roles))
+	if err != nil {
+		return errors.Wrap(err, "listing roles")
+	}
+	bindingList, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(o.TeamNs).List(o.listOptions(environmentrolebindings))
+	if err != nil {
+		return errors.Wrap(err, "listing environment role bindings")
+	}
+	envList, err := o.JxClient.JenkinsV1().Environments(o.TeamNs).List(o.listOptions(environments))
+	if err != nil {
+		return errors.Wrap(err, "listing environments")
+	}
+
+	teamRoles := sets.NewString()
+	environmentRoles := sets.NewString()
+	for idx := range roleList.Items {
+		role := &roleList.Items[idx]
+		teamRoles.Insert(role.Name)
+		if kube.IsEnvironmentRole(role.Labels) {
+			environmentRoles.Insert(role.Name)
+		}
+	}
+
+	desiredRoles := map[string]sets.String{}
+	desiredRoleBindings := map[string]sets.String{}
+	for idx := range envList.Items {
+		env := &envList.Items[idx]
+		ns := env.Spec.Namespace
+		if ns == "" {
+			continue
+		}
+		if desiredRoles[ns] == nil {
+			desiredRoles[ns] = sets.NewString()
+			desiredRoleBindings[ns] = sets.NewString()
+		}
+		desiredRoles[ns].Insert(environmentRoles.UnsortedList()...)
+		for i := range bindingList.Items {
+			binding := &bindingList.Items[i]
+			if binding.DeletionTimestamp != nil || !kube.BindingMatchesEnvironment(env, binding) {
+				continue
+			}
+			if binding.Spec.RoleRef.Kind == kindClusterRole && !o.clusterRoleAllowed(binding.Spec.RoleRef.Name) {
+				continue
+			}
+			desiredRoleBindings[ns].Insert(binding.Name)
+			if binding.Spec.RoleRef.Kind != kindClusterRole && teamRoles.Has(binding.Spec.RoleRef.Name) {
+				desiredRoles[ns].Insert(binding.Spec.RoleRef.Name)
+			}
+		}
+	}
+
+	namespaces := make([]string, 0, len(desiredRoles))
+	for ns := range desiredRoles {
+		namespaces = append(namespaces, ns)
+	}
+	ownedRoles, ownedRoleBindings, err := o.listOwned(namespaces)
+	if err != nil {
+		return err
+	}
+
+	var errorMap []error
+	for ns, names := range ownedRoles {
+		// the team namespace holds the source Roles so we never prune Roles from it
+		if ns == o.TeamNs {
+			continue
+		}
+		for _, name := range names {
+			if !desiredRoles[ns].Has(name) {
+				log.Logger().Infof("pruning orphaned Role %s in namespace %s", name, ns)
+				errorMap = append(errorMap, o.deleteOwnedRole(ns, name, nil))
+			}
+		}
+	}
+	for ns, names := range ownedRoleBindings {
+		owned := sets.NewString(names...)
+		for _, name := range names {
+			// the bridge keeps the subjects bound while the RoleBinding is recreated for a new roleRef, so while the
+			// RoleBinding is missing it is the only grant and is left for the recreate to remove
+			if strings.HasSuffix(name, roleRefBridgeSuffix) {
+				bound := strings.TrimSuffix(name, roleRefBridgeSuffix)
+				if desiredRoleBindings[ns].Has(bound) && !owned.Has(bound) {
+					continue
+				}
+			}
+			if !desiredRoleBindings[ns].Has(name) {
+				log.Logger().Infof("pruning orphaned RoleBinding %s in namespace %s", name, ns)
+				errorMap = append(errorMap, o.deleteOwnedRoleBinding(ns, name, nil))
+			}
+		}
+	}
+	return util.CombineErrors(errorMap...)
+}
+
+// ownedSelector selects the Roles and RoleBindings created by the controller for this team
+func (o *RoleOptions) ownedSelector() string {
+	return labels.SelectorFromSet(map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      o.TeamNs,
+	}).String()
+}
+
+// listOwned returns the names of the Roles and RoleBindings owned by the team keyed by namespace. They are listed
+// across all namespaces so that those left in the namespaces of environments deleted while we were not running are
+// found too. Without the permission to do so only the given namespaces of the current environments are listed
+func (o *RoleOptions) listOwned(namespaces []string) (map[string][]string, map[string][]string, error) {
+	options := metav1.ListOptions{LabelSelector: o.ownedSelector()}
+	ownedRoles := map[string][]string{}
+	ownedRoleBindings := map[string][]string{}
+	roleList, err := o.KubeClient.RbacV1().Roles(metav1.NamespaceAll).List(options)
+	if err == nil {
+		var roleBindingList *rbacv1.RoleBindingList
+		roleBindingList, err = o.KubeClient.RbacV1().RoleBindings(metav1.NamespaceAll).List(options)
+		if err == nil {
+			for idx := range roleList.Items {
+				role := &roleList.Items[idx]
+				ownedRoles[role.Namespace] = append(ownedRoles[role.Namespace], role.Name)
+			}
+			for idx := range roleBindingList.Items {
+				roleBinding := &roleBindingList.Items[idx]
+				ownedRoleBindings[roleBinding.Namespace] = append(ownedRoleBindings[roleBinding.Namespace], roleBinding.Name)
+			}
+			return ownedRoles, ownedRoleBindings, nil
+		}
+	}
+	if !apierrors.IsForbidden(err) {
+		return nil, nil, errors.Wrap(err, "listing the roles and role bindings of the team in all namespaces")
+	}
+	log.Logger().Warnf("only pruning the namespaces of the current environments as listing roles and role bindings in all namespaces is forbidden: %s", err)
+	for _, ns := range namespaces {
+		roleList, err := o.KubeClient.RbacV1().Roles(ns).List(options)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "listing roles in namespace %s", ns)
+		}
+		for idx := range roleList.Items {
+			ownedRoles[ns] = append(ownedRoles[ns], roleList.Items[idx].Name)
+		}
+		roleBindingList, err := o.KubeClient.RbacV1().RoleBindings(ns).List(options)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "listing role bindings in namespace %s", ns)
+		}
+		for idx := range roleBindingList.Items {
+			ownedRoleBindings[ns] = append(ownedRoleBindings[ns], roleBindingList.Items[idx].Name)
+		}
+	}
+	return ownedRoles, ownedRoleBindings, nil
+}
+
+// prunePeriodically runs the prune pass logging any failures so that the next pass can try again. As a pass compares
+// everything the team owns with its sources a successful one counts as a full sync
+func (o *RoleOptions) prunePeriodically() {
+	err := o.Prune()
+	if err != nil {
+		log.Logger().Warnf("failed to prune orphaned roles and role bindings: %s", err)
+		return
+	}
+	metrics.RecordFullSync(o.TeamNs)
+}
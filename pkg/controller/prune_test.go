@@ -0,0 +1,379 @@
+package controller_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	jxfake "github.com/jenkins-x/jx-api/pkg/client/clientset/versioned/fake"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_Prune(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	ownedLabels := map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      teamNs,
+	}
+	roleRef := rbacv1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "Role",
+		Name:     "myrole",
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"get", "watch", "list"},
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "pods", "services"},
+			},
+		},
+	}
+	envRoleBinding := &v1.EnvironmentRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mybinding",
+			Namespace: teamNs,
+		},
+		Spec: v1.EnvironmentRoleBindingSpec{
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      "jenkins",
+					Namespace: teamNs,
+				},
+			},
+			RoleRef: roleRef,
+			Environments: []v1.EnvironmentFilter{
+				{
+					Includes: []string{"staging"},
+				},
+			},
+		},
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			role,
+			// orphans left behind by a deleted EnvironmentRole and EnvironmentRoleBinding
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "jx-staging", Labels: ownedLabels},
+			},
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "jx-staging", Labels: ownedLabels},
+				RoleRef:    roleRef,
+			},
+			// no longer matched by the environment filter of the binding
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "mybinding", Namespace: "jx-production", Labels: ownedLabels},
+				RoleRef:    roleRef,
+			},
+			// not created by the controller
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "handmade", Namespace: "jx-staging"},
+				RoleRef:    roleRef,
+			},
+			// owned by another team
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "other",
+					Namespace: "jx-staging",
+					Labels: map[string]string{
+						kube.LabelCreatedBy: kube.ValueCreatedByJX,
+						kube.LabelTeam:      "other",
+					},
+				},
+				RoleRef: roleRef,
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			kube.NewPermanentEnvironment("production"),
+			envRoleBinding,
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	err = o.Prune()
+	require.NoError(t, err)
+
+	_, err = o.KubeClient.RbacV1().Roles("jx-staging").Get("stale", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "orphaned Role stale should have been pruned")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("stale", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "orphaned RoleBinding stale should have been pruned")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-production").Get("mybinding", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "unmatched RoleBinding mybinding should have been pruned")
+
+	for _, ns := range []string{"jx-staging", "jx-production"} {
+		_, err = o.KubeClient.RbacV1().Roles(ns).Get("myrole", metav1.GetOptions{})
+		assert.NoError(t, err, "Role myrole should be kept in namespace %s", ns)
+	}
+	for _, name := range []string{"mybinding", "handmade", "other"} {
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get(name, metav1.GetOptions{})
+		assert.NoError(t, err, "RoleBinding %s should be kept in namespace jx-staging", name)
+	}
+}
+
+func Test_SyncPrunesAsPlanned(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch:       true,
+		PruneInterval: time.Minute,
+		Out:           ioutil.Discard,
+	}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			// left behind by a deleted EnvironmentRoleBinding
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "stale",
+					Namespace: "jx-staging",
+					Labels: map[string]string{
+						kube.LabelCreatedBy: kube.ValueCreatedByJX,
+						kube.LabelTeam:      teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "Role",
+					Name:     "myrole",
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+		},
+	)
+
+	o.DryRun = true
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []controller.Change{
+		{
+			Operation: "delete",
+			Kind:      "RoleBinding",
+			Namespace: "jx-staging",
+			Name:      "stale",
+		},
+	}, o.Changes(), "the plan should include the prune")
+
+	o.DryRun = false
+	err = o.Run(context.Background())
+	require.NoError(t, err)
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("stale", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the sync should prune the orphaned RoleBinding as planned")
+}
+
+func Test_PruneDeletedEnvironment(t *testing.T) {
+	t.Parallel()
+	teamNs := "jx"
+	ownedLabels := map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      teamNs,
+	}
+	roleRef := rbacv1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "Role",
+		Name:     "myrole",
+	}
+	for _, forbidden := range []bool{false, true} {
+		o := &controller.RoleOptions{
+			NoWatch: true,
+		}
+		testhelpers.ConfigureTestOptionsWithResources(o,
+			[]runtime.Object{
+				// left in the namespace of an Environment deleted while the controller was not running
+				&rbacv1.Role{
+					ObjectMeta: metav1.ObjectMeta{Name: "myrole", Namespace: "jx-deleted", Labels: ownedLabels},
+				},
+				&rbacv1.RoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "mybinding", Namespace: "jx-deleted", Labels: ownedLabels},
+					RoleRef:    roleRef,
+				},
+				&rbacv1.RoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "jx-staging", Labels: ownedLabels},
+					RoleRef:    roleRef,
+				},
+			},
+			[]runtime.Object{
+				kube.NewPermanentEnvironment("staging"),
+			},
+		)
+		if forbidden {
+			o.KubeClient.(*fake.Clientset).PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetNamespace() != metav1.NamespaceAll {
+					return false, nil, nil
+				}
+				return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", nil)
+			})
+		}
+
+		err := o.Prune()
+		require.NoError(t, err, "pruning when listing all namespaces is forbidden is %v", forbidden)
+
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("stale", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "orphaned RoleBinding in an environment namespace should be pruned when listing all namespaces is forbidden is %v", forbidden)
+		_, err = o.KubeClient.RbacV1().Roles("jx-deleted").Get("myrole", metav1.GetOptions{})
+		assert.Equal(t, !forbidden, apierrors.IsNotFound(err), "Role in the namespace of the deleted Environment pruned when listing all namespaces is forbidden is %v", forbidden)
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-deleted").Get("mybinding", metav1.GetOptions{})
+		assert.Equal(t, !forbidden, apierrors.IsNotFound(err), "RoleBinding in the namespace of the deleted Environment pruned when listing all namespaces is forbidden is %v", forbidden)
+	}
+}
+
+func Test_PruneKeepsRoleRefBridge(t *testing.T) {
+	t.Parallel()
+	teamNs := "jx"
+	ownedLabels := map[string]string{
+		kube.LabelCreatedBy: kube.ValueCreatedByJX,
+		kube.LabelTeam:      teamNs,
+	}
+	roleRef := rbacv1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "Role",
+		Name:     "myrole",
+	}
+	for _, recreated := range []bool{false, true} {
+		o := &controller.RoleOptions{
+			NoWatch: true,
+		}
+		kubeObjects := []runtime.Object{
+			// the bridge of a RoleBinding which is being recreated for a new roleRef
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "mybinding-roleref-bridge", Namespace: "jx-staging", Labels: ownedLabels},
+				RoleRef:    roleRef,
+			},
+			// the bridge of an EnvironmentRoleBinding which has since been deleted
+			&rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "deleted-roleref-bridge", Namespace: "jx-staging", Labels: ownedLabels},
+				RoleRef:    roleRef,
+			},
+		}
+		if recreated {
+			kubeObjects = append(kubeObjects, &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "mybinding", Namespace: "jx-staging", Labels: ownedLabels},
+				RoleRef:    roleRef,
+			})
+		}
+		testhelpers.ConfigureTestOptionsWithResources(o,
+			kubeObjects,
+			[]runtime.Object{
+				kube.NewPermanentEnvironment("staging"),
+				&v1.EnvironmentRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "mybinding",
+						Namespace: teamNs,
+					},
+					Spec: v1.EnvironmentRoleBindingSpec{
+						Subjects: []rbacv1.Subject{
+							{
+								Kind:      "ServiceAccount",
+								Name:      "jenkins",
+								Namespace: teamNs,
+							},
+						},
+						RoleRef: roleRef,
+					},
+				},
+			},
+		)
+
+		err := o.Prune()
+		require.NoError(t, err, "pruning when the RoleBinding has been recreated is %v", recreated)
+
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding-roleref-bridge", metav1.GetOptions{})
+		assert.Equal(t, recreated, apierrors.IsNotFound(err), "bridge pruned when the RoleBinding has been recreated is %v", recreated)
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("deleted-roleref-bridge", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "bridge of a deleted EnvironmentRoleBinding should be pruned when the RoleBinding has been recreated is %v", recreated)
+	}
+}
+
+func Test_RemoveEnvironment(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		ShutdownTimeout: 10 * time.Second,
+	}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the controller should become ready")
+	waitForWatch(t, &o.JxClient.(*jxfake.Clientset).Fake, "environments")
+
+	_, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err, "the Role should be propagated to the environment")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err, "the RoleBinding should be propagated to the environment")
+
+	err = o.JxClient.JenkinsV1().Environments(teamNs).Delete("staging", nil)
+	require.NoError(t, err)
+	waitFor(t, func() bool {
+		_, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+		return apierrors.IsNotFound(err)
+	}, "the Role should be removed from the namespace of the deleted Environment")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the RoleBinding should be removed from the namespace of the deleted Environment")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+// maxRetries is the number of times a work item is retried with exponential back-off before it is dropped
+// and left for the next resync
+const maxRetries = 15
+
+// workItem is the key of a resource which needs reconciling
+type workItem struct {
+	resource string
+	key      string
+}
+
+// initialPass tracks the work items of the resources listed when the watches first synced. The controller is ready
+// once each of them has been reconciled once, whether or not that succeeded, so one failing namespace neither stops
+// the controller nor keeps it unready while it is retried
+type initialPass struct {
+	lock    sync.Mutex
+	pending map[workItem]bool
+	done    bool
+}
+
+// enqueue adds the key of the given resource object onto the work queue
+func (o *RoleOptions) enqueue(resource string, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Logger().Warnf("failed to get the key of %s %#v: %s", resource, obj, err)
+		return
+	}
+	o.queue.Add(workItem{resource: resource, key: key})
+	metrics.SetQueueDepth(o.TeamNs, o.queue.Len())
+}
+
+// runWorkers starts the configured number of workers processing the work queue until the stop channel is closed.
+// The workers are added to the wait group so that in-flight reconciles can be drained on shutdown
+func (o *RoleOptions) runWorkers(stop <-chan struct{}, wg *sync.WaitGroup) {
+	workers := o.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	log.Logger().Infof("starting %d workers", workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(func() {
+				o.runWorker(stop)
+			}, time.Second, stop)
+		}()
+	}
+	go func() {
+		<-stop
+		o.queue.ShutDown()
+	}()
+}
+
+func (o *RoleOptions) runWorker(stop <-chan struct{}) {
+	for o.processNextWorkItem(stop) {
+	}
+}
+
+// processNextWorkItem reconciles the next item on the work queue, requeueing it with back-off if it fails.
+// Returns false when the queue has been shut down
+func (o *RoleOptions) processNextWorkItem(stop <-chan struct{}) bool {
+	obj, shutdown := o.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer o.queue.Done(obj)
+
+	// don't start any new reconciles once we are stopping, they get picked up again on the next start
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	metrics.SetQueueDepth(o.TeamNs, o.queue.Len())
+
+	item := obj.(workItem)
+	start := time.Now()
+	o.health.reconcileStarted(item, start)
+	err := o.reconcile(item)
+	o.health.reconcileFinished(item)
+	metrics.ObserveReconcile(item.resource, start, err)
+	defer o.initialItemReconciled(item)
+	if err == nil {
+		o.queue.Forget(obj)
+		return true
+	}
+	if o.queue.NumRequeues(obj) < maxRetries {
+		log.Logger().Warnf("failed to reconcile %s %s, retrying: %s", item.resource, item.key, err)
+		o.queue.AddRateLimited(obj)
+		return true
+	}
+	log.Logger().Errorf("failed to reconcile %s %s, giving up after %d retries: %s", item.resource, item.key, maxRetries, err)
+	o.queue.Forget(obj)
+	return true
+}
+
+// startInitialPass records the resources in the watch stores, which the initial list of the watches has just
+// enqueued, so that we know when they have all been reconciled
+func (o *RoleOptions) startInitialPass() {
+	pending := map[workItem]bool{}
+	for resource, store := range o.stores {
+		for _, key := range store.ListKeys() {
+			pending[workItem{resource: resource, key: key}] = true
+		}
+	}
+	log.Logger().Infof("reconciling %d resources listed by the watches", len(pending))
+	o.initial.lock.Lock()
+	o.initial.pending = pending
+	done := len(pending) == 0
+	o.initial.done = done
+	o.initial.lock.Unlock()
+	if done {
+		o.initialPassDone()
+	}
+}
+
+// initialItemReconciled records that the work item has been reconciled, completing the initial pass if it was the
+// last one pending
+func (o *RoleOptions) initialItemReconciled(item workItem) {
+	o.initial.lock.Lock()
+	if o.initial.done || !o.initial.pending[item] {
+		o.initial.lock.Unlock()
+		return
+	}
+	delete(o.initial.pending, item)
+	done := len(o.initial.pending) == 0
+	o.initial.done = done
+	o.initial.lock.Unlock()
+	if done {
+		o.initialPassDone()
+	}
+}
+
+// initialPassDone finishes the startup reconciliation once every listed resource has been reconciled
+func (o *RoleOptions) initialPassDone() {
+	o.upsertRoleIntoEnvRole()
+	log.Logger().Info("reconciled all the resources listed by the watches")
+	metrics.RecordFullSync(o.TeamNs)
+	o.health.setReady(true)
+}
+
+// reconcile looks up the current state of the work item in the informer store and reconciles it
+func (o *RoleOptions) reconcile(item workItem) error {
+	store := o.stores[item.resource]
+	if store == nil {
+		return errors.Errorf("no store for resource %s", item.resource)
+	}
+	obj, exists, err := store.GetByKey(item.key)
+	if err != nil {
+		return errors.Wrapf(err, "getting %s %s from the store", item.resource, item.key)
+	}
+	_, name, err := cache.SplitMetaNamespaceKey(item.key)
+	if err != nil {
+		return errors.Wrapf(err, "splitting key %s", item.key)
+	}
+
+	switch item.resource {
+	case roles:
+		var role *rbacv1.Role
+		if exists {
+			role = obj.(*rbacv1.Role)
+		}
+		return o.onRole(name, role)
+	case environmentrolebindings:
+		var binding *v1.EnvironmentRoleBinding
+		if exists {
+			binding = obj.(*v1.EnvironmentRoleBinding)
+		}
+		return o.onEnvironmentRoleBinding(name, binding)
+	case environments:
+		var env *v1.Environment
+		if exists {
+			env = obj.(*v1.Environment)
+		}
+		err = o.onEnvironment(o.reconciledEnvironment(name), env)
+		if err != nil {
+			return err
+		}
+		o.storeReconciledEnvironment(name, env)
+		return nil
+	case clusterroles:
+		return o.onClusterRole(name)
+	default:
+		return errors.Errorf("unknown resource %s", item.resource)
+	}
+}
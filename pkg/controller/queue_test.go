@@ -0,0 +1,211 @@
+package controller_test
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newQueueTestRole(teamNs string) *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myrole",
+			Namespace: teamNs,
+			Labels: map[string]string{
+				kube.LabelKind: kube.ValueKindEnvironmentRole,
+			},
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				Verbs:     []string{"get"},
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+			},
+		},
+	}
+}
+
+func newQueueTestRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, 10*time.Millisecond)
+}
+
+func Test_WorkQueueRetriesFailedReconcile(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{}
+	teamNs := "jx"
+	binding := &v1.EnvironmentRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mybinding",
+			Namespace: teamNs,
+		},
+		Spec: v1.EnvironmentRoleBindingSpec{
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      "jenkins",
+					Namespace: teamNs,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     "myrole",
+			},
+			Environments: []v1.EnvironmentFilter{
+				{
+					Includes: []string{"staging"},
+				},
+			},
+		},
+	}
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{newQueueTestRole(teamNs)},
+		[]runtime.Object{kube.NewPermanentEnvironment("staging"), binding},
+	)
+	failures := 1
+	o.KubeClient.(*fake.Clientset).PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, apierrors.NewServiceUnavailable("try again later")
+	})
+	o.UseTestQueue(newQueueTestRateLimiter())
+	role, err := o.KubeClient.RbacV1().Roles(teamNs).Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, o.Enqueue("roles", role))
+	require.NoError(t, o.Enqueue("environmentrolebindings", binding))
+
+	require.True(t, o.ProcessNextWorkItem())
+	require.True(t, o.ProcessNextWorkItem())
+	assert.Equal(t, 1, o.NumRequeues("environmentrolebindings", "jx/mybinding"), "requeues after the failure")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the RoleBinding should not have been created yet")
+
+	// the retry waits for the back-off before the item is handed out again
+	require.True(t, o.ProcessNextWorkItem())
+	assert.Equal(t, 0, o.NumRequeues("environmentrolebindings", "jx/mybinding"), "requeues are forgotten after the retry succeeded")
+	assert.Equal(t, 0, o.QueueLen())
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.NoError(t, err, "the RoleBinding should be created by the retry")
+}
+
+func Test_WorkQueueGivesUpAfterMaxRetries(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{newQueueTestRole(teamNs)},
+		[]runtime.Object{kube.NewPermanentEnvironment("staging")},
+	)
+	o.KubeClient.(*fake.Clientset).PrependReactor("create", "roles", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "roles"}, "myrole", nil)
+	})
+	o.UseTestQueue(newQueueTestRateLimiter())
+	role, err := o.KubeClient.RbacV1().Roles(teamNs).Get("myrole", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, o.Enqueue("roles", role))
+
+	for i := 1; i <= controller.MaxRetries; i++ {
+		require.True(t, o.ProcessNextWorkItem())
+		assert.Equal(t, i, o.NumRequeues("roles", "jx/myrole"), "requeues after %d failures", i)
+	}
+	require.True(t, o.ProcessNextWorkItem())
+	assert.Equal(t, 0, o.NumRequeues("roles", "jx/myrole"), "requeues are forgotten once we give up")
+	assert.Equal(t, 0, o.QueueLen(), "the item should not be requeued once we give up")
+}
+
+// roleSyncStatus returns the sync status of the Role in the namespaces of the EnvironmentRoleBinding
+func roleSyncStatus(t *testing.T, o *controller.RoleOptions, teamNs, name string) map[string]bool {
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	status, err := controller.GetSyncStatus(binding)
+	require.NoError(t, err)
+	answer := map[string]bool{}
+	if status != nil {
+		for _, s := range status.Namespaces {
+			answer[s.Namespace] = s.RoleInSync
+		}
+	}
+	return answer
+}
+
+func Test_WorkQueueBindingBeforeRole(t *testing.T) {
+	t.Parallel()
+	teamNs := "jx"
+	binding := &v1.EnvironmentRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mybinding",
+			Namespace: teamNs,
+		},
+		Spec: v1.EnvironmentRoleBindingSpec{
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      "ServiceAccount",
+					Name:      "jenkins",
+					Namespace: teamNs,
+				},
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     "myrole",
+			},
+			Environments: []v1.EnvironmentFilter{
+				{
+					Includes: []string{"staging"},
+				},
+			},
+		},
+	}
+	newOptions := func() *controller.RoleOptions {
+		o := &controller.RoleOptions{}
+		testhelpers.ConfigureTestOptionsWithResources(o,
+			[]runtime.Object{newQueueTestRole(teamNs)},
+			[]runtime.Object{kube.NewPermanentEnvironment("staging"), binding.DeepCopy()},
+		)
+		o.UseTestQueue(newQueueTestRateLimiter())
+		return o
+	}
+
+	t.Run("watched role", func(t *testing.T) {
+		t.Parallel()
+		o := newOptions()
+		// the watch has listed the Role but the binding is reconciled first
+		require.NoError(t, o.Enqueue("environmentrolebindings", binding.DeepCopy()))
+		require.NoError(t, o.Enqueue("roles", newQueueTestRole(teamNs)))
+
+		require.True(t, o.ProcessNextWorkItem())
+		_, err := o.KubeClient.RbacV1().Roles("jx-staging").Get("myrole", metav1.GetOptions{})
+		assert.NoError(t, err, "the binding should copy the watched Role before it is reconciled")
+		assert.Equal(t, map[string]bool{"jx-staging": true}, roleSyncStatus(t, o, teamNs, "mybinding"))
+	})
+
+	t.Run("role created later", func(t *testing.T) {
+		t.Parallel()
+		o := newOptions()
+		require.NoError(t, o.Enqueue("environmentrolebindings", binding.DeepCopy()))
+		require.True(t, o.ProcessNextWorkItem())
+		assert.Equal(t, map[string]bool{"jx-staging": false}, roleSyncStatus(t, o, teamNs, "mybinding"),
+			"the Role is missing until it is watched")
+
+		require.NoError(t, o.Enqueue("roles", newQueueTestRole(teamNs)))
+		require.True(t, o.ProcessNextWorkItem())
+		assert.Equal(t, map[string]bool{"jx-staging": true}, roleSyncStatus(t, o, teamNs, "mybinding"),
+			"reconciling the Role should refresh the status of the binding")
+	})
+}
@@ -0,0 +1,111 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_RoleRefChange(t *testing.T) {
+	t.Parallel()
+	tests := map[bool][]string{
+		false: {"delete mybinding", "create mybinding"},
+		true:  {"create mybinding-roleref-bridge", "delete mybinding", "create mybinding", "delete mybinding-roleref-bridge"},
+	}
+	for noGap, expectedWrites := range tests {
+		recorder := record.NewFakeRecorder(100)
+		o := &controller.RoleOptions{
+			NoWatch:      true,
+			RoleRefNoGap: noGap,
+			Recorder:     recorder,
+		}
+		teamNs := "jx"
+		testhelpers.ConfigureTestOptionsWithResources(o,
+			[]runtime.Object{
+				&rbacv1.RoleBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "mybinding",
+						Namespace: "jx-staging",
+						Labels: map[string]string{
+							kube.LabelCreatedBy: kube.ValueCreatedByJX,
+							kube.LabelTeam:      teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "oldrole",
+					},
+				},
+			},
+			[]runtime.Object{
+				kube.NewPermanentEnvironment("staging"),
+				&v1.EnvironmentRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "mybinding",
+						Namespace: teamNs,
+					},
+					Spec: v1.EnvironmentRoleBindingSpec{
+						Subjects: []rbacv1.Subject{
+							{
+								Kind:      "ServiceAccount",
+								Name:      "jenkins",
+								Namespace: teamNs,
+							},
+						},
+						RoleRef: rbacv1.RoleRef{
+							APIGroup: "rbac.authorization.k8s.io",
+							Kind:     "Role",
+							Name:     "newrole",
+						},
+						Environments: []v1.EnvironmentFilter{
+							{
+								Includes: []string{"staging"},
+							},
+						},
+					},
+				},
+			},
+		)
+		kubeClient := o.KubeClient.(*fake.Clientset)
+		kubeClient.ClearActions()
+
+		err := o.Run(context.Background())
+		require.NoError(t, err)
+
+		roleBinding, err := o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "newrole", roleBinding.RoleRef.Name, "roleRef of RoleBinding when no gap is %v", noGap)
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding-roleref-bridge", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err), "temporary RoleBinding should be removed when no gap is %v", noGap)
+
+		var writes []string
+		for _, action := range kubeClient.Actions() {
+			if action.GetNamespace() != "jx-staging" || action.GetResource().Resource != "rolebindings" {
+				continue
+			}
+			switch action.GetVerb() {
+			case "create":
+				writes = append(writes, "create "+action.(k8stesting.CreateAction).GetObject().(*rbacv1.RoleBinding).Name)
+			case "delete":
+				writes = append(writes, "delete "+action.(k8stesting.DeleteAction).GetName())
+			}
+		}
+		assert.Equal(t, expectedWrites, writes, "RoleBinding writes when no gap is %v", noGap)
+
+		assert.Contains(t, drainEvents(recorder), "Normal RoleRefChanged Recreating RoleBinding mybinding in namespace jx-staging as its roleRef changed from Role oldrole to Role newrole")
+	}
+}
@@ -0,0 +1,140 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// runInBackground runs the controller in watch mode until the returned context is cancelled, the error of Run is
+// sent on the returned channel
+func runInBackground(o *controller.RoleOptions) (context.CancelFunc, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- o.Run(ctx)
+	}()
+	return cancel, errs
+}
+
+// waitFor polls the condition until it is true, failing the test if it takes too long
+func waitFor(t *testing.T, condition func() bool, message string, args ...interface{}) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			require.FailNowf(t, "timed out", message, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForRun waits for Run to return after its context was cancelled
+func waitForRun(t *testing.T, errs <-chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out waiting for Run to return")
+		return nil
+	}
+}
+
+// waitForWatch waits until the fake client is watching the resource, as the fake clients drop the changes made
+// before the watch starts
+func waitForWatch(t *testing.T, client *k8stesting.Fake, resource string) {
+	waitFor(t, func() bool {
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "watch" && action.GetResource().Resource == resource {
+				return true
+			}
+		}
+		return false
+	}, "watching %s", resource)
+}
+
+func Test_WatchModeKeepsRetryingFailedNamespaces(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		ShutdownTimeout: 10 * time.Second,
+	}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			kube.NewPermanentEnvironment("production"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+					Environments: []v1.EnvironmentFilter{
+						{
+							Includes: []string{"staging", "production"},
+						},
+					},
+				},
+			},
+		},
+	)
+	// production stays forbidden for a while, which used to stop the controller at startup
+	failures := 5
+	o.KubeClient.(*fake.Clientset).PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "jx-production" || failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"}, "mybinding", nil)
+	})
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+
+	waitFor(t, o.IsReady, "the controller should become ready even though a namespace is failing")
+	_, err := o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.NoError(t, err, "the RoleBinding should be propagated to the namespaces which are not failing")
+	waitFor(t, func() bool {
+		_, err := o.KubeClient.RbacV1().RoleBindings("jx-production").Get("mybinding", metav1.GetOptions{})
+		return err == nil
+	}, "the failing namespace should be retried until the RoleBinding is created")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
@@ -0,0 +1,275 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	jxfake "github.com/jenkins-x/jx-api/pkg/client/clientset/versioned/fake"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_EnvironmentSelector(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(100)
+	o := &controller.RoleOptions{
+		NoWatch:             true,
+		Recorder:            recorder,
+		AllowedClusterRoles: []string{"view"},
+	}
+	teamNs := "jx"
+	newEnvironment := func(name string, labels map[string]string) *v1.Environment {
+		env := kube.NewPermanentEnvironment(name)
+		env.Labels = labels
+		return env
+	}
+	newBinding := func(name, selector string, includes ...string) *v1.EnvironmentRoleBinding {
+		binding := &v1.EnvironmentRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+				Annotations: map[string]string{
+					kube.AnnotationEnvironmentSelector: selector,
+				},
+			},
+			Spec: v1.EnvironmentRoleBindingSpec{
+				Subjects: []rbacv1.Subject{
+					{
+						Kind:      "ServiceAccount",
+						Name:      "jenkins",
+						Namespace: teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "ClusterRole",
+					Name:     "view",
+				},
+			},
+		}
+		if len(includes) > 0 {
+			binding.Spec.Environments = []v1.EnvironmentFilter{
+				{
+					Includes: includes,
+				},
+			}
+		}
+		return binding
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "view",
+				},
+			},
+		},
+		[]runtime.Object{
+			newEnvironment("staging", map[string]string{"tier": "staging", "region": "eu"}),
+			newEnvironment("production", map[string]string{"tier": "production", "region": "us"}),
+			newEnvironment("apac", map[string]string{"tier": "production", "region": "apac"}),
+			newBinding("production", "tier=production"),
+			newBinding("western", "region in (eu,us)"),
+			// the selector and the environment filters both have to match
+			newBinding("filtered", "tier=production", "apac", "staging"),
+			newBinding("invalid", "tier in ("),
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	expected := map[string][]string{
+		"production": {"jx-production", "jx-apac"},
+		"western":    {"jx-staging", "jx-production"},
+		"filtered":   {"jx-apac"},
+	}
+	for _, name := range []string{"production", "western", "filtered", "invalid"} {
+		for _, ns := range []string{"jx-staging", "jx-production", "jx-apac"} {
+			_, err = o.KubeClient.RbacV1().RoleBindings(ns).Get(name, metav1.GetOptions{})
+			if util.StringArrayIndex(expected[name], ns) >= 0 {
+				assert.NoError(t, err, "RoleBinding %s should be created in namespace %s", name, ns)
+			} else {
+				assert.True(t, apierrors.IsNotFound(err), "RoleBinding %s should not be created in namespace %s", name, ns)
+			}
+		}
+	}
+	assertEvents(t, drainEvents(recorder),
+		"Warning InvalidEnvironmentSelector Matches no environments: parsing jenkins.io/environment-selector annotation \"tier in (\"",
+	)
+}
+
+func Test_EnvironmentRelabelledOutOfSelector(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		ShutdownTimeout: 10 * time.Second,
+	}
+	teamNs := "jx"
+	env := kube.NewPermanentEnvironment("staging")
+	env.Labels = map[string]string{"tier": "production"}
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+				},
+			},
+		},
+		[]runtime.Object{
+			env,
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "production",
+					Namespace: teamNs,
+					Annotations: map[string]string{
+						kube.AnnotationEnvironmentSelector: "tier=production",
+					},
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+	roleBindingExists := func() bool {
+		_, err := o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("production", metav1.GetOptions{})
+		return err == nil
+	}
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, o.IsReady, "the controller should become ready")
+	waitForWatch(t, &o.JxClient.(*jxfake.Clientset).Fake, "environments")
+	waitForWatch(t, &o.JxClient.(*jxfake.Clientset).Fake, "environmentrolebindings")
+	require.True(t, roleBindingExists(), "RoleBinding should be created in the selected environment")
+
+	// relabelling the Environment out of the selector revokes the RoleBinding
+	env, err := o.JxClient.JenkinsV1().Environments(teamNs).Get("staging", metav1.GetOptions{})
+	require.NoError(t, err)
+	env.Labels["tier"] = "test"
+	_, err = o.JxClient.JenkinsV1().Environments(teamNs).Update(env)
+	require.NoError(t, err)
+	waitFor(t, func() bool {
+		return !roleBindingExists()
+	}, "the RoleBinding should be deleted once the Environment no longer matches the selector")
+
+	// so does changing the selector of the EnvironmentRoleBinding
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get("production", metav1.GetOptions{})
+	require.NoError(t, err)
+	binding.Annotations[kube.AnnotationEnvironmentSelector] = "tier=test"
+	_, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Update(binding)
+	require.NoError(t, err)
+	waitFor(t, roleBindingExists, "the RoleBinding should be created once the selector matches the Environment again")
+	// the status records where the binding was propagated, so edit the binding with it rather than a stale copy
+	waitFor(t, func() bool {
+		binding, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get("production", metav1.GetOptions{})
+		require.NoError(t, err)
+		status, err := controller.GetSyncStatus(binding)
+		return err == nil && status != nil && len(status.Namespaces) == 1
+	}, "the status should record the RoleBinding in the environment namespace")
+	binding.Annotations[kube.AnnotationEnvironmentSelector] = "tier=production"
+	_, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Update(binding)
+	require.NoError(t, err)
+	waitFor(t, func() bool {
+		return !roleBindingExists()
+	}, "the RoleBinding should be deleted once the selector no longer matches the Environment")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
+
+func Test_EnvironmentsSharingNamespace(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	staging := kube.NewPermanentEnvironment("staging")
+	staging.Labels = map[string]string{"tier": "production"}
+	// shares the namespace of staging but is not matched by the binding
+	shared := kube.NewPermanentEnvironment("shared")
+	shared.Spec.Namespace = "jx-staging"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+				},
+			},
+		},
+		[]runtime.Object{
+			staging,
+			shared,
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "production",
+					Namespace: teamNs,
+					Annotations: map[string]string{
+						kube.AnnotationEnvironmentSelector: "tier=production",
+					},
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+	kubeClient := o.KubeClient.(*fake.Clientset)
+
+	for i := 1; i <= 3; i++ {
+		err := o.Run(context.Background())
+		require.NoError(t, err, "sync %d", i)
+
+		_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("production", metav1.GetOptions{})
+		assert.NoError(t, err, "RoleBinding should be kept in the shared namespace after sync %d", i)
+		binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get("production", metav1.GetOptions{})
+		require.NoError(t, err)
+		status, err := controller.GetSyncStatus(binding)
+		require.NoError(t, err)
+		if assert.NotNil(t, status, "status after sync %d", i) && assert.Len(t, status.Namespaces, 1, "status after sync %d", i) {
+			assert.Equal(t, "staging", status.Namespaces[0].Environment, "status after sync %d", i)
+			assert.True(t, status.Namespaces[0].InSync(), "status after sync %d", i)
+		}
+	}
+	for _, action := range kubeClient.Actions() {
+		assert.False(t, action.GetVerb() == "delete" && action.GetResource().Resource == "rolebindings",
+			"the RoleBinding in the shared namespace should never be deleted: %v", action)
+	}
+}
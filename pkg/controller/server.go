@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+)
+
+// serveMetrics exposes the prometheus metrics on the metrics address in the background
+func (o *RoleOptions) serveMetrics() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return serve("metrics", o.MetricsAddr, mux)
+}
+
+// serveHealth exposes the liveness and readiness endpoints on the health address in the background
+func (o *RoleOptions) serveHealth() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", o.health.healthz)
+	mux.HandleFunc("/readyz", o.health.readyz)
+	return serve("health", o.HealthAddr, mux)
+}
+
+// serve serves the handler on the address in the background, returning nil if the address is empty
+func serve(name, addr string, handler http.Handler) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	log.Logger().Infof("serving %s on %s", name, addr)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Logger().Errorf("failed to serve %s on %s: %s", name, addr, err)
+		}
+	}()
+	return server
+}
+
+// shutdownServers gracefully stops the servers
+func (o *RoleOptions) shutdownServers(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Logger().Warnf("failed to shut down server on %s: %s", server.Addr, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package controller_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// blockRoleBindingCreates blocks the creates of RoleBindings until the returned release channel is closed, the
+// namespace of the first blocked create is sent on the returned started channel
+func blockRoleBindingCreates(client *fake.Clientset) (started <-chan string, release chan struct{}) {
+	startedCh := make(chan string, 1)
+	release = make(chan struct{})
+	var once sync.Once
+	client.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		once.Do(func() {
+			startedCh <- action.GetNamespace()
+		})
+		<-release
+		return false, nil, nil
+	})
+	return startedCh, release
+}
+
+func Test_ShutdownDrainsInFlightReconciles(t *testing.T) {
+	t.Parallel()
+	o := newHealthOptions(time.Minute)
+	started, release := blockRoleBindingCreates(o.KubeClient.(*fake.Clientset))
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	ns := <-started
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	require.NoError(t, waitForRun(t, errs), "Run should wait for the in-flight reconcile")
+
+	_, err := o.KubeClient.RbacV1().RoleBindings(ns).Get("mybinding", metav1.GetOptions{})
+	assert.NoError(t, err, "the in-flight reconcile should have finished creating the RoleBinding")
+}
+
+func Test_ShutdownTimesOutWaitingForInFlightReconciles(t *testing.T) {
+	t.Parallel()
+	o := newHealthOptions(time.Minute)
+	o.ShutdownTimeout = 100 * time.Millisecond
+	started, release := blockRoleBindingCreates(o.KubeClient.(*fake.Clientset))
+	defer close(release)
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	<-started
+
+	cancel()
+	err := waitForRun(t, errs)
+	require.Error(t, err, "Run should give up waiting for the stuck reconcile")
+	assert.Contains(t, err.Error(), "timed out after 100ms waiting for in-flight reconciles to finish")
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// SyncStatus is written as JSON to the AnnotationSyncStatus annotation of each EnvironmentRoleBinding
+type SyncStatus struct {
+	// ObservedGeneration is the generation of the EnvironmentRoleBinding the status was computed for
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// Namespaces is the state of each environment namespace the EnvironmentRoleBinding matches, sorted by namespace
+	Namespaces []NamespaceSyncStatus `json:"namespaces"`
+}
+
+// NamespaceSyncStatus is the state of the Role and RoleBinding propagated into one environment namespace
+type NamespaceSyncStatus struct {
+	Namespace   string `json:"namespace"`
+	Environment string `json:"environment"`
+	// RoleInSync is true if the Role matches the team Role, it is always true in the team namespace where the Role
+	// is not copied
+	RoleInSync bool `json:"roleInSync"`
+	// RoleBindingInSync is true if the RoleBinding matches the EnvironmentRoleBinding
+	RoleBindingInSync bool `json:"roleBindingInSync"`
+	// LastSyncTime is when the namespace was last brought in sync
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastError is why the namespace is not in sync
+	LastError string `json:"lastError,omitempty"`
+}
+
+// InSync returns true if both the Role and RoleBinding are in sync
+func (s *NamespaceSyncStatus) InSync() bool {
+	return s.RoleInSync && s.RoleBindingInSync
+}
+
+// setNamespaceStatus remembers the outcome of propagating the EnvironmentRoleBinding into the namespace
+func (o *RoleOptions) setNamespaceStatus(bindingName, ns, envName string, roleErr, bindingErr error) {
+	status := NamespaceSyncStatus{
+		Namespace:         ns,
+		Environment:       envName,
+		RoleInSync:        roleErr == nil,
+		RoleBindingInSync: bindingErr == nil,
+	}
+	for _, err := range []error{roleErr, bindingErr} {
+		if err != nil {
+			status.LastError = err.Error()
+			break
+		}
+	}
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+	if o.syncStatus == nil {
+		o.syncStatus = map[string]map[string]NamespaceSyncStatus{}
+	}
+	if o.syncStatus[bindingName] == nil {
+		o.syncStatus[bindingName] = map[string]NamespaceSyncStatus{}
+	}
+	o.syncStatus[bindingName][ns] = status
+}
+
+// clearNamespaceStatus forgets the namespace as the EnvironmentRoleBinding no longer propagates into it for the
+// environment, leaving the status written for another environment sharing the namespace
+func (o *RoleOptions) clearNamespaceStatus(bindingName, ns, envName string) {
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+	if status, ok := o.syncStatus[bindingName][ns]; ok && status.Environment == envName {
+		delete(o.syncStatus[bindingName], ns)
+	}
+}
+
+// hasNamespaceStatus returns true if the EnvironmentRoleBinding was propagated into the namespace for the environment,
+// as remembered since it was last reconciled or as written to its status annotation before then
+func (o *RoleOptions) hasNamespaceStatus(binding *v1.EnvironmentRoleBinding, ns, envName string) bool {
+	o.statusLock.Lock()
+	status, ok := o.syncStatus[binding.Name][ns]
+	o.statusLock.Unlock()
+	if ok {
+		return status.Environment == envName
+	}
+	written, err := GetSyncStatus(binding)
+	if err != nil || written == nil {
+		return false
+	}
+	for _, s := range written.Namespaces {
+		if s.Namespace == ns {
+			return s.Environment == envName
+		}
+	}
+	return false
+}
+
+// forgetStatus forgets every namespace of the EnvironmentRoleBinding before it is reconciled from scratch or deleted
+func (o *RoleOptions) forgetStatus(bindingName string) {
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+	delete(o.syncStatus, bindingName)
+}
+
+// namespaceStatuses returns the remembered namespaces of the EnvironmentRoleBinding sorted by namespace
+func (o *RoleOptions) namespaceStatuses(bindingName string) []NamespaceSyncStatus {
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+	answer := []NamespaceSyncStatus{}
+	for _, status := range o.syncStatus[bindingName] {
+		answer = append(answer, status)
+	}
+	sort.Slice(answer, func(i, j int) bool {
+		return answer[i].Namespace < answer[j].Namespace
+	})
+	return answer
+}
+
+func copyNamespaces(namespaces []NamespaceSyncStatus) []NamespaceSyncStatus {
+	return append([]NamespaceSyncStatus{}, namespaces...)
+}
+
+// statusOnlyUpdate returns true if the update of the EnvironmentRoleBinding only changed the sync status annotation,
+// which is caused by the controller writing it so there is nothing to reconcile
+func statusOnlyUpdate(oldObj, newObj interface{}) bool {
+	oldBinding, ok := oldObj.(*v1.EnvironmentRoleBinding)
+	if !ok {
+		return false
+	}
+	newBinding, ok := newObj.(*v1.EnvironmentRoleBinding)
+	if !ok {
+		return false
+	}
+	if oldBinding.Annotations[kube.AnnotationSyncStatus] == newBinding.Annotations[kube.AnnotationSyncStatus] {
+		return false
+	}
+	oldCopy := oldBinding.DeepCopy()
+	newCopy := newBinding.DeepCopy()
+	for _, b := range []*v1.EnvironmentRoleBinding{oldCopy, newCopy} {
+		delete(b.Annotations, kube.AnnotationSyncStatus)
+		if len(b.Annotations) == 0 {
+			b.Annotations = nil
+		}
+		b.ResourceVersion = ""
+		b.ManagedFields = nil
+	}
+	return equality.Semantic.DeepEqual(oldCopy, newCopy)
+}
+
+// GetSyncStatus parses the sync status annotation of the EnvironmentRoleBinding, returning nil if there is none
+func GetSyncStatus(binding *v1.EnvironmentRoleBinding) (*SyncStatus, error) {
+	text := binding.Annotations[kube.AnnotationSyncStatus]
+	if text == "" {
+		return nil, nil
+	}
+	status := &SyncStatus{}
+	err := json.Unmarshal([]byte(text), status)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing annotation %s of environment role binding %s", kube.AnnotationSyncStatus, binding.Name)
+	}
+	return status, nil
+}
+
+// writeStatus writes the remembered namespaces into the annotation of the EnvironmentRoleBinding. The annotation is
+// only updated if something other than the sync times changed so that the update events we cause settle down
+func (o *RoleOptions) writeStatus(binding *v1.EnvironmentRoleBinding) {
+	if o.DryRun {
+		return
+	}
+	namespaces := o.namespaceStatuses(binding.Name)
+	// avoid fetching the binding if the copy we were given is already up to date
+	if old, err := GetSyncStatus(binding); err == nil && old != nil && binding.Generation == old.ObservedGeneration {
+		if mergeSyncStatus(old, &SyncStatus{ObservedGeneration: binding.Generation, Namespaces: copyNamespaces(namespaces)}) == nil {
+			return
+		}
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(binding.Namespace).Get(binding.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.DeletionTimestamp != nil {
+			return nil
+		}
+		old, err := GetSyncStatus(current)
+		if err != nil {
+			log.Logger().Warnf("replacing invalid status: %s", err)
+		}
+		status := mergeSyncStatus(old, &SyncStatus{
+			ObservedGeneration: binding.Generation,
+			Namespaces:         copyNamespaces(namespaces),
+		})
+		if status == nil {
+			return nil
+		}
+		data, err := json.Marshal(status)
+		if err != nil {
+			return errors.Wrap(err, "marshalling status")
+		}
+		updated := current.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[kube.AnnotationSyncStatus] = string(data)
+		_, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings(binding.Namespace).Update(updated)
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Logger().Warnf("failed to write the status of environment role binding %s: %s", binding.Name, err)
+	}
+}
+
+// mergeSyncStatus returns the status to write, keeping the sync times of namespaces which are unchanged, or nil if
+// the status has not changed
+func mergeSyncStatus(old, status *SyncStatus) *SyncStatus {
+	previous := map[string]NamespaceSyncStatus{}
+	if old != nil {
+		for _, s := range old.Namespaces {
+			previous[s.Namespace] = s
+		}
+	}
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	for i := range status.Namespaces {
+		s := &status.Namespaces[i]
+		s.LastSyncTime = previous[s.Namespace].LastSyncTime
+		if s.InSync() {
+			p, ok := previous[s.Namespace]
+			if !ok || !p.InSync() || p.LastSyncTime == nil {
+				s.LastSyncTime = &now
+			}
+		}
+	}
+	if old != nil && equality.Semantic.DeepEqual(old, status) {
+		return nil
+	}
+	return status
+}
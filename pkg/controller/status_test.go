@@ -0,0 +1,112 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	jxfake "github.com/jenkins-x/jx-api/pkg/client/clientset/versioned/fake"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_SyncStatus(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	teamNs := "jx"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			kube.NewPermanentEnvironment("staging"),
+			kube.NewPermanentEnvironment("production"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "mybinding",
+					Namespace:  teamNs,
+					Generation: 3,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+					Environments: []v1.EnvironmentFilter{
+						{
+							Includes: []string{"staging", "production"},
+						},
+					},
+				},
+			},
+		},
+	)
+	o.KubeClient.(*fake.Clientset).PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "jx-production" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"}, "mybinding", nil)
+	})
+
+	err := o.Run(context.Background())
+	require.Error(t, err)
+
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(teamNs).Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	status, err := controller.GetSyncStatus(binding)
+	require.NoError(t, err)
+	require.NotNil(t, status, "no status annotation %s", kube.AnnotationSyncStatus)
+	assert.Equal(t, int64(3), status.ObservedGeneration)
+	require.Len(t, status.Namespaces, 2)
+
+	production := status.Namespaces[0]
+	assert.Equal(t, "jx-production", production.Namespace)
+	assert.Equal(t, "production", production.Environment)
+	assert.True(t, production.RoleInSync)
+	assert.False(t, production.RoleBindingInSync)
+	assert.Contains(t, production.LastError, "forbidden")
+	assert.Nil(t, production.LastSyncTime)
+
+	staging := status.Namespaces[1]
+	assert.Equal(t, "jx-staging", staging.Namespace)
+	assert.True(t, staging.InSync())
+	assert.Empty(t, staging.LastError)
+	assert.NotNil(t, staging.LastSyncTime)
+
+	// reconciling again without any changes should not write the status again
+	jxClient := o.JxClient.(*jxfake.Clientset)
+	jxClient.ClearActions()
+	err = o.UpsertEnvironmentRoleBinding(binding)
+	require.Error(t, err)
+	for _, action := range jxClient.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb(), "unexpected %s of %s", action.GetVerb(), action.GetResource().Resource)
+	}
+}
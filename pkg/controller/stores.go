@@ -0,0 +1,132 @@
+package controller
+
+import (
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	jxlisters "github.com/jenkins-x/jx-api/pkg/client/listers/jenkins.io/v1"
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// initStores lazily creates the thread safe stores which are shared between the workers and the initial sync. The
+// Roles and EnvRoleBindings listers list the reconciled resources until listFromWatches backs them with the watches
+func (o *RoleOptions) initStores() {
+	o.storesOnce.Do(func() {
+		indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+		o.roleIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+		o.bindingIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+		o.Roles = rbaclisters.NewRoleLister(o.roleIndexer)
+		o.EnvRoleBindings = jxlisters.NewEnvironmentRoleBindingLister(o.bindingIndexer)
+		o.environments = map[string]*v1.Environment{}
+	})
+}
+
+// listFromWatches backs the Roles and EnvRoleBindings listers with the stores of the watches, which hold every watched
+// Role and EnvironmentRoleBinding rather than only the ones reconciled so far, so a binding reconciled before its Role
+// still finds it
+func (o *RoleOptions) listFromWatches() {
+	if indexer, ok := o.stores[roles].(cache.Indexer); ok {
+		o.Roles = rbaclisters.NewRoleLister(indexer)
+	}
+	if indexer, ok := o.stores[environmentrolebindings].(cache.Indexer); ok {
+		o.EnvRoleBindings = jxlisters.NewEnvironmentRoleBindingLister(indexer)
+	}
+}
+
+// getRole returns the team Role of the given name or nil if it does not exist
+func (o *RoleOptions) getRole(name string) *rbacv1.Role {
+	role, err := o.Roles.Roles(o.TeamNs).Get(name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Logger().Warnf("failed to get role %s: %s", name, err)
+		}
+		return nil
+	}
+	return role
+}
+
+// listRoles returns all the team Roles
+func (o *RoleOptions) listRoles() []*rbacv1.Role {
+	answer, err := o.Roles.Roles(o.TeamNs).List(labels.Everything())
+	if err != nil {
+		log.Logger().Warnf("failed to list roles: %s", err)
+	}
+	return answer
+}
+
+// reconciledRole returns the last reconciled state of the team Role of the given name or nil if it has not been
+// reconciled
+func (o *RoleOptions) reconciledRole(name string) *rbacv1.Role {
+	obj, exists, err := o.roleIndexer.GetByKey(o.TeamNs + "/" + name)
+	if err != nil {
+		log.Logger().Warnf("failed to get role %s: %s", name, err)
+	}
+	if !exists {
+		return nil
+	}
+	return obj.(*rbacv1.Role)
+}
+
+func (o *RoleOptions) storeRole(role *rbacv1.Role) {
+	err := o.roleIndexer.Add(role)
+	if err != nil {
+		log.Logger().Warnf("failed to store role %s: %s", role.Name, err)
+	}
+}
+
+func (o *RoleOptions) forgetRole(name string) {
+	err := o.roleIndexer.Delete(cache.ExplicitKey(o.TeamNs + "/" + name))
+	if err != nil {
+		log.Logger().Warnf("failed to forget role %s: %s", name, err)
+	}
+}
+
+// listEnvironmentRoleBindings returns all the team EnvironmentRoleBindings which are not being deleted
+func (o *RoleOptions) listEnvironmentRoleBindings() []*v1.EnvironmentRoleBinding {
+	bindings, err := o.EnvRoleBindings.EnvironmentRoleBindings(o.TeamNs).List(labels.Everything())
+	if err != nil {
+		log.Logger().Warnf("failed to list environment role bindings: %s", err)
+	}
+	var answer []*v1.EnvironmentRoleBinding
+	for _, binding := range bindings {
+		if binding.DeletionTimestamp == nil {
+			answer = append(answer, binding)
+		}
+	}
+	return answer
+}
+
+func (o *RoleOptions) storeEnvironmentRoleBinding(binding *v1.EnvironmentRoleBinding) {
+	err := o.bindingIndexer.Add(binding)
+	if err != nil {
+		log.Logger().Warnf("failed to store environment role binding %s: %s", binding.Name, err)
+	}
+}
+
+func (o *RoleOptions) forgetEnvironmentRoleBinding(name string) {
+	err := o.bindingIndexer.Delete(cache.ExplicitKey(o.TeamNs + "/" + name))
+	if err != nil {
+		log.Logger().Warnf("failed to forget environment role binding %s: %s", name, err)
+	}
+}
+
+// reconciledEnvironment returns the last reconciled state of the environment of the given name
+func (o *RoleOptions) reconciledEnvironment(name string) *v1.Environment {
+	o.environmentsLock.RLock()
+	defer o.environmentsLock.RUnlock()
+	return o.environments[name]
+}
+
+// storeReconciledEnvironment records the last reconciled state of the environment, removing it if env is nil
+func (o *RoleOptions) storeReconciledEnvironment(name string, env *v1.Environment) {
+	o.environmentsLock.Lock()
+	defer o.environmentsLock.Unlock()
+	if env == nil {
+		delete(o.environments, name)
+		return
+	}
+	o.environments[name] = env
+}
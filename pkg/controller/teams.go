@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	teamNamespacesEnvVar         = "JX_CONTROLLER_TEAM_NAMESPACES"
+	teamSelectorEnvVar           = "JX_CONTROLLER_TEAM_SELECTOR"
+	teamDiscoveryIntervalEnvVar  = "JX_CONTROLLER_TEAM_DISCOVERY_INTERVAL"
+	defaultTeamDiscoveryInterval = time.Minute
+)
+
+// loadTeamsEnv configures multi-team mode from the environment variables
+func (o *RoleOptions) loadTeamsEnv() error {
+	o.TeamNamespaces = append(o.TeamNamespaces, splitList(os.Getenv(teamNamespacesEnvVar))...)
+	if value, ok := os.LookupEnv(teamSelectorEnvVar); ok {
+		o.TeamSelector = value
+	}
+	return durationEnvVar(teamDiscoveryIntervalEnvVar, &o.TeamDiscoveryInterval)
+}
+
+// MultiTeam returns true if the controller processes the teams of the TeamNamespaces and TeamSelector rather than
+// the single TeamNs
+func (o *RoleOptions) MultiTeam() bool {
+	return len(o.TeamNamespaces) > 0 || o.TeamSelector != ""
+}
+
+// validateTeams returns an error if the team selector cannot be parsed
+func (o *RoleOptions) validateTeams() error {
+	if o.TeamSelector == "" {
+		return nil
+	}
+	_, err := labels.Parse(o.TeamSelector)
+	return errors.Wrapf(err, "parsing team selector %q", o.TeamSelector)
+}
+
+// discoverTeams returns the sorted team namespaces, those listed explicitly followed by the ones matching the
+// team selector. Terminating namespaces matching the selector are only included if includeTerminating is true, so a
+// watching controller keeps processing them until the EnvironmentRoleBindings deleted with them are finalized
+func (o *RoleOptions) discoverTeams(includeTerminating bool) ([]string, error) {
+	var answer []string
+	add := func(ns string) {
+		if util.StringArrayIndex(answer, ns) < 0 {
+			answer = append(answer, ns)
+		}
+	}
+	for _, ns := range o.TeamNamespaces {
+		add(ns)
+	}
+	if o.TeamSelector != "" {
+		namespaces, err := o.KubeClient.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: o.TeamSelector})
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing the team namespaces matching %s", o.TeamSelector)
+		}
+		for _, ns := range namespaces.Items {
+			if includeTerminating || ns.Status.Phase != corev1.NamespaceTerminating {
+				add(ns.Name)
+			}
+		}
+	}
+	sort.Strings(answer)
+	return answer, nil
+}
+
+// newTeam returns a controller for the team namespace sharing the clients and configuration of this controller but
+// none of its state, so the teams are isolated from each other. Every exported option is copied so new options reach
+// the teams, while the unexported fields hold the state of this controller and are left zero, other than the kube
+// config. The metrics and health servers and the leader election are left to this controller, which runs them
+func (o *RoleOptions) newTeam(ns string) *RoleOptions {
+	team := &RoleOptions{}
+	// the state includes locks so the options are copied field by field rather than copying the whole struct
+	from, to := reflect.ValueOf(o).Elem(), reflect.ValueOf(team).Elem()
+	for i := 0; i < from.NumField(); i++ {
+		if from.Type().Field(i).PkgPath == "" {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+	team.kubeConfig = o.kubeConfig
+	team.TeamNs = ns
+	team.TeamNamespaces = nil
+	team.TeamSelector = ""
+	// the listers read the stores of this controller, the team creates its own
+	team.Roles = nil
+	team.EnvRoleBindings = nil
+	team.initStores()
+	return team
+}
+
+// dryRunTeams adds the changes a sync of each team would make to the plan
+func (o *RoleOptions) dryRunTeams() error {
+	namespaces, err := o.discoverTeams(false)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		team := o.newTeam(ns)
+		err = team.dryRun()
+		if err != nil {
+			return errors.Wrapf(err, "computing the plan of team %s", ns)
+		}
+		for _, change := range team.Changes() {
+			o.plan.add(change)
+		}
+	}
+	return nil
+}
+
+// runTeams runs a controller for each team namespace. Without NoWatch the teams are discovered again every
+// TeamDiscoveryInterval, starting controllers for new teams and stopping the ones for removed teams. The finalizers
+// of the EnvironmentRoleBindings of a removed team are released once its controller has stopped
+func (o *RoleOptions) runTeams(ctx context.Context) error {
+	if o.NoWatch {
+		namespaces, err := o.discoverTeams(false)
+		if err != nil {
+			return err
+		}
+		var errs []error
+		for _, ns := range namespaces {
+			errs = append(errs, errors.Wrapf(o.newTeam(ns).run(ctx), "syncing team %s", ns))
+		}
+		return util.CombineErrors(errs...)
+	}
+
+	type runningTeam struct {
+		controller *RoleOptions
+		cancel     context.CancelFunc
+		removed    bool
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	teams := map[string]*runningTeam{}
+
+	discover := func() {
+		namespaces, err := o.discoverTeams(true)
+		if err != nil {
+			log.Logger().Errorf("failed to discover the teams: %s", err)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for ns, team := range teams {
+			if util.StringArrayIndex(namespaces, ns) < 0 {
+				log.Logger().Infof("stopping controller for removed team %s", ns)
+				team.removed = true
+				team.cancel()
+				delete(teams, ns)
+			}
+		}
+		for _, ns := range namespaces {
+			if teams[ns] != nil {
+				continue
+			}
+			log.Logger().Infof("starting controller for team %s", ns)
+			teamCtx, cancel := context.WithCancel(ctx)
+			team := &runningTeam{controller: o.newTeam(ns), cancel: cancel}
+			teams[ns] = team
+			o.health.addChild(&team.controller.health)
+			wg.Add(1)
+			go func(ns string) {
+				defer wg.Done()
+				err := team.controller.run(teamCtx)
+				if err != nil {
+					log.Logger().Errorf("controller for team %s stopped: %s", ns, err)
+				}
+				o.health.removeChild(&team.controller.health)
+				metrics.ForgetTeam(ns)
+				// forget the team so the next discovery starts it again if it still exists
+				lock.Lock()
+				removed := team.removed
+				if teams[ns] == team {
+					delete(teams, ns)
+				}
+				lock.Unlock()
+				cancel()
+				if removed {
+					// the finalizers are released after the controller stopped so it cannot add them back
+					err = team.controller.releaseFinalizers()
+					if err != nil {
+						log.Logger().Errorf("failed to release the finalizers of removed team %s: %s", ns, err)
+					}
+				}
+			}(ns)
+		}
+	}
+
+	interval := o.TeamDiscoveryInterval
+	if interval <= 0 {
+		interval = defaultTeamDiscoveryInterval
+	}
+	discover()
+	o.health.setReady(true)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			discover()
+		case <-ctx.Done():
+			log.Logger().Info("stopping the team controllers")
+			wg.Wait()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package controller_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	jxfake "github.com/jenkins-x/jx-api/pkg/client/clientset/versioned/fake"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_MultiTeam(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+	}
+	newRole := func(teamNs, resource string) *rbacv1.Role {
+		return &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "myrole",
+				Namespace: teamNs,
+				Labels: map[string]string{
+					kube.LabelKind: kube.ValueKindEnvironmentRole,
+				},
+			},
+			Rules: []rbacv1.PolicyRule{
+				{
+					Verbs:     []string{"get"},
+					APIGroups: []string{""},
+					Resources: []string{resource},
+				},
+			},
+		}
+	}
+	newEnvironment := func(teamNs, name string) *v1.Environment {
+		env := kube.NewPermanentEnvironment(name)
+		env.Namespace = teamNs
+		env.Spec.Namespace = teamNs + "-" + name
+		return env
+	}
+	newBinding := func(teamNs, name string) *v1.EnvironmentRoleBinding {
+		return &v1.EnvironmentRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: teamNs,
+			},
+			Spec: v1.EnvironmentRoleBindingSpec{
+				Subjects: []rbacv1.Subject{
+					{
+						Kind:      "ServiceAccount",
+						Name:      "jenkins",
+						Namespace: teamNs,
+					},
+				},
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: "rbac.authorization.k8s.io",
+					Kind:     "Role",
+					Name:     "myrole",
+				},
+			},
+		}
+	}
+	teamNamespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+		}
+	}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			teamNamespace("team-b", map[string]string{"jenkins.io/team": "true"}),
+			// not a team as it does not match the selector
+			teamNamespace("other", nil),
+			newRole("jx", "configmaps"),
+			newRole("team-b", "secrets"),
+			newRole("other", "pods"),
+		},
+		[]runtime.Object{
+			newEnvironment("jx", "staging"),
+			newEnvironment("team-b", "staging"),
+			newEnvironment("other", "staging"),
+			newBinding("jx", "a-binding"),
+			newBinding("team-b", "b-binding"),
+			newBinding("other", "other-binding"),
+		},
+	)
+	o.TeamNamespaces = []string{"jx"}
+	o.TeamSelector = "jenkins.io/team=true"
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	expected := map[string]struct {
+		team     string
+		resource string
+		binding  string
+	}{
+		"jx-staging":     {"jx", "configmaps", "a-binding"},
+		"team-b-staging": {"team-b", "secrets", "b-binding"},
+	}
+	for ns, e := range expected {
+		role, err := o.KubeClient.RbacV1().Roles(ns).Get("myrole", metav1.GetOptions{})
+		if assert.NoError(t, err, "Role myrole in namespace %s", ns) {
+			assert.Equal(t, []string{e.resource}, role.Rules[0].Resources, "Role myrole in namespace %s", ns)
+			assert.Equal(t, e.team, role.Labels[kube.LabelTeam], "team label of Role myrole in namespace %s", ns)
+		}
+		roleBindings, err := o.KubeClient.RbacV1().RoleBindings(ns).List(metav1.ListOptions{})
+		require.NoError(t, err)
+		if assert.Len(t, roleBindings.Items, 1, "RoleBindings in namespace %s", ns) {
+			assert.Equal(t, e.binding, roleBindings.Items[0].Name, "RoleBinding in namespace %s", ns)
+			assert.Equal(t, e.team, roleBindings.Items[0].Labels[kube.LabelTeam], "team label of RoleBinding in namespace %s", ns)
+		}
+	}
+	_, err = o.KubeClient.RbacV1().Roles("other-staging").Get("myrole", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the namespace other is not a team so nothing should be propagated")
+}
+
+// newWatchedTeamOptions returns the options of a controller watching the team namespace team-b, labelled as a team,
+// which propagates one Role and EnvironmentRoleBinding to its staging environment
+func newWatchedTeamOptions() *controller.RoleOptions {
+	o := &controller.RoleOptions{
+		TeamSelector:          "jenkins.io/team=true",
+		TeamDiscoveryInterval: 20 * time.Millisecond,
+		ShutdownTimeout:       10 * time.Second,
+	}
+	teamNs := "team-b"
+	env := kube.NewPermanentEnvironment("staging")
+	env.Namespace = teamNs
+	env.Spec.Namespace = teamNs + "-staging"
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{
+			&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   teamNs,
+					Labels: map[string]string{"jenkins.io/team": "true"},
+				},
+			},
+			&rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "myrole",
+					Namespace: teamNs,
+					Labels: map[string]string{
+						kube.LabelKind: kube.ValueKindEnvironmentRole,
+					},
+				},
+			},
+		},
+		[]runtime.Object{
+			env,
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+	return o
+}
+
+// hasFinalizer returns true if the EnvironmentRoleBinding mybinding of team-b has the controller finalizer
+func hasFinalizer(t *testing.T, o *controller.RoleOptions) bool {
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings("team-b").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	for _, finalizer := range binding.Finalizers {
+		if finalizer == kube.FinalizerRoleController {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_RemovedTeamReleasesFinalizers(t *testing.T) {
+	t.Parallel()
+	o := newWatchedTeamOptions()
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, func() bool {
+		return hasFinalizer(t, o)
+	}, "the controller of the team should add its finalizer")
+
+	namespace, err := o.KubeClient.CoreV1().Namespaces().Get("team-b", metav1.GetOptions{})
+	require.NoError(t, err)
+	namespace.Labels = nil
+	_, err = o.KubeClient.CoreV1().Namespaces().Update(namespace)
+	require.NoError(t, err)
+
+	waitFor(t, func() bool {
+		return !hasFinalizer(t, o)
+	}, "the finalizer should be released once the namespace is no longer a team")
+	_, err = o.KubeClient.RbacV1().RoleBindings("team-b-staging").Get("mybinding", metav1.GetOptions{})
+	assert.NoError(t, err, "the RoleBinding of a team which is no longer processed should be left alone")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
+
+func Test_TerminatingTeamFinalizesBindings(t *testing.T) {
+	t.Parallel()
+	o := newWatchedTeamOptions()
+
+	cancel, errs := runInBackground(o)
+	defer cancel()
+	waitFor(t, func() bool {
+		return hasFinalizer(t, o)
+	}, "the controller of the team should add its finalizer")
+	waitForWatch(t, &o.JxClient.(*jxfake.Clientset).Fake, "environmentrolebindings")
+
+	namespace, err := o.KubeClient.CoreV1().Namespaces().Get("team-b", metav1.GetOptions{})
+	require.NoError(t, err)
+	namespace.Status.Phase = corev1.NamespaceTerminating
+	_, err = o.KubeClient.CoreV1().Namespaces().Update(namespace)
+	require.NoError(t, err)
+	// let a few discoveries see the terminating namespace before its bindings are deleted
+	time.Sleep(100 * time.Millisecond)
+
+	// the deletion of the namespace deletes the binding, which is blocked by our finalizer
+	binding, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings("team-b").Get("mybinding", metav1.GetOptions{})
+	require.NoError(t, err)
+	now := metav1.NewTime(time.Now())
+	binding.DeletionTimestamp = &now
+	_, err = o.JxClient.JenkinsV1().EnvironmentRoleBindings("team-b").Update(binding)
+	require.NoError(t, err)
+
+	waitFor(t, func() bool {
+		return !hasFinalizer(t, o)
+	}, "the controller of the terminating team should finalize the binding")
+	_, err = o.KubeClient.RbacV1().RoleBindings("team-b-staging").Get("mybinding", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the RoleBinding of the deleted binding should be removed")
+
+	cancel()
+	assert.NoError(t, waitForRun(t, errs))
+}
+
+func Test_NewTeamCopiesOptions(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		TeamSelector:          "jenkins.io/team=true",
+		TeamNamespaces:        []string{"jx"},
+		TeamDiscoveryInterval: time.Second,
+		Workers:               3,
+		ServerSideApply:       true,
+		AllowedClusterRoles:   []string{"view"},
+		ControllerNamespace:   "jx",
+		PruneInterval:         time.Minute,
+		LivenessTimeout:       time.Minute,
+		Metadata: controller.MetadataOptions{
+			AllowPrefixes: []string{"example.com/"},
+		},
+	}
+	testhelpers.ConfigureTestOptionsWithResources(o, nil, nil)
+
+	team := o.NewTeam("team-b")
+	assert.Equal(t, "team-b", team.TeamNs)
+	assert.False(t, team.MultiTeam(), "the team should not discover teams itself")
+	assert.NotNil(t, team.Roles, "the team should list its own Roles")
+	assert.NotNil(t, team.EnvRoleBindings, "the team should list its own EnvironmentRoleBindings")
+
+	from, to := reflect.ValueOf(o).Elem(), reflect.ValueOf(team).Elem()
+	for i := 0; i < from.NumField(); i++ {
+		field := from.Type().Field(i)
+		switch field.Name {
+		case "TeamNs", "TeamNamespaces", "TeamSelector", "Roles", "EnvRoleBindings":
+			continue
+		}
+		if field.PkgPath == "" {
+			assert.Equal(t, from.Field(i).Interface(), to.Field(i).Interface(), "option %s of the team", field.Name)
+		}
+	}
+}
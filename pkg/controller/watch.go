@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchOptions restricts and tunes the watch of one kind of resource in the team namespace
+type WatchOptions struct {
+	// ResyncPeriod overrides RoleOptions.ResyncPeriod for the resource when it is not zero
+	ResyncPeriod time.Duration
+	// FieldSelector only watches the resources matching the field selector, e.g. metadata.name=dev
+	FieldSelector string
+	// LabelSelector only watches the resources matching the label selector, e.g. jenkins.io/kind=EnvironmentRole
+	LabelSelector string
+}
+
+const (
+	// suffixes of the per resource environment variables, e.g. JX_CONTROLLER_ROLES_LABEL_SELECTOR
+	resyncPeriodEnvVarSuffix  = "_RESYNC_PERIOD"
+	fieldSelectorEnvVarSuffix = "_FIELD_SELECTOR"
+	labelSelectorEnvVarSuffix = "_LABEL_SELECTOR"
+)
+
+// WatchedResources the resources in the team namespace the controller watches, which can be configured in Watches
+var WatchedResources = []string{roles, environmentrolebindings, environments}
+
+// loadWatchEnv overrides the watch options of each resource from its environment variables
+func (o *RoleOptions) loadWatchEnv() error {
+	for _, resource := range WatchedResources {
+		prefix := "JX_CONTROLLER_" + strings.ToUpper(resource)
+		watch := o.Watches[resource]
+		err := durationEnvVar(prefix+resyncPeriodEnvVarSuffix, &watch.ResyncPeriod)
+		if err != nil {
+			return err
+		}
+		if value, ok := os.LookupEnv(prefix + fieldSelectorEnvVarSuffix); ok {
+			watch.FieldSelector = value
+		}
+		if value, ok := os.LookupEnv(prefix + labelSelectorEnvVarSuffix); ok {
+			watch.LabelSelector = value
+		}
+		if watch != (WatchOptions{}) {
+			if o.Watches == nil {
+				o.Watches = map[string]WatchOptions{}
+			}
+			o.Watches[resource] = watch
+		}
+	}
+	return nil
+}
+
+// validateWatches checks the resources and selectors of the watch options so mistakes fail at startup
+func (o *RoleOptions) validateWatches() error {
+	for resource, watch := range o.Watches {
+		if !isWatchedResource(resource) {
+			return errors.Errorf("cannot configure the watch of %s, expecting one of %s", resource, strings.Join(WatchedResources, ", "))
+		}
+		_, err := fields.ParseSelector(watch.FieldSelector)
+		if err != nil {
+			return errors.Wrapf(err, "parsing field selector of %s", resource)
+		}
+		_, err = labels.Parse(watch.LabelSelector)
+		if err != nil {
+			return errors.Wrapf(err, "parsing label selector of %s", resource)
+		}
+	}
+	return nil
+}
+
+func isWatchedResource(resource string) bool {
+	for _, r := range WatchedResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// resyncPeriod returns the resync period of the watch of the resource
+func (o *RoleOptions) resyncPeriod(resource string) time.Duration {
+	if period := o.Watches[resource].ResyncPeriod; period != 0 {
+		return period
+	}
+	return o.ResyncPeriod
+}
+
+// listOptions returns the options to list the resource with so that full syncs see the same resources as the watch
+func (o *RoleOptions) listOptions(resource string) metav1.ListOptions {
+	watch := o.Watches[resource]
+	return metav1.ListOptions{
+		FieldSelector: watch.FieldSelector,
+		LabelSelector: watch.LabelSelector,
+	}
+}
+
+// listWatch returns the list and watch of the resource through the typed clients, restricted by its watch options
+func (o *RoleOptions) listWatch(resource string) *cache.ListWatch {
+	var listFunc func(options metav1.ListOptions) (runtime.Object, error)
+	var watchFunc func(options metav1.ListOptions) (watch.Interface, error)
+	switch resource {
+	case roles:
+		client := o.KubeClient.RbacV1().Roles(o.TeamNs)
+		listFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		}
+		watchFunc = client.Watch
+	case clusterroles:
+		client := o.KubeClient.RbacV1().ClusterRoles()
+		listFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		}
+		watchFunc = client.Watch
+	case environments:
+		client := o.JxClient.JenkinsV1().Environments(o.TeamNs)
+		listFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		}
+		watchFunc = client.Watch
+	case environmentrolebindings:
+		client := o.JxClient.JenkinsV1().EnvironmentRoleBindings(o.TeamNs)
+		listFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(options)
+		}
+		watchFunc = client.Watch
+	}
+	restrict := func(options *metav1.ListOptions) {
+		listOptions := o.listOptions(resource)
+		options.FieldSelector = listOptions.FieldSelector
+		options.LabelSelector = listOptions.LabelSelector
+	}
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			restrict(&options)
+			return listFunc(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			restrict(&options)
+			return watchFunc(options)
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/controller"
+	"github.com/jenkins-x/jx-role-controller/pkg/kube"
+	"github.com/jenkins-x/jx-role-controller/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_WatchLabelSelector(t *testing.T) {
+	t.Parallel()
+	o := &controller.RoleOptions{
+		NoWatch: true,
+		Watches: map[string]controller.WatchOptions{
+			"environments": {
+				LabelSelector: "rbac=managed",
+			},
+		},
+	}
+	teamNs := "jx"
+	staging := kube.NewPermanentEnvironment("staging")
+	staging.Labels = map[string]string{"rbac": "managed"}
+
+	testhelpers.ConfigureTestOptionsWithResources(o,
+		[]runtime.Object{},
+		[]runtime.Object{
+			staging,
+			kube.NewPermanentEnvironment("production"),
+			&v1.EnvironmentRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "mybinding",
+					Namespace: teamNs,
+				},
+				Spec: v1.EnvironmentRoleBindingSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind:      "ServiceAccount",
+							Name:      "jenkins",
+							Namespace: teamNs,
+						},
+					},
+					RoleRef: rbacv1.RoleRef{
+						APIGroup: "rbac.authorization.k8s.io",
+						Kind:     "Role",
+						Name:     "myrole",
+					},
+				},
+			},
+		},
+	)
+
+	err := o.Run(context.Background())
+	require.NoError(t, err)
+
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-staging").Get("mybinding", metav1.GetOptions{})
+	assert.NoError(t, err, "RoleBinding should be created in the selected environment")
+	_, err = o.KubeClient.RbacV1().RoleBindings("jx-production").Get("mybinding", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "RoleBinding should not be created in an environment excluded by the selector")
+}
+
+func Test_InvalidWatchOptions(t *testing.T) {
+	t.Parallel()
+	tests := map[string]controller.WatchOptions{
+		"pods":         {},
+		"roles":        {LabelSelector: "a in (b"},
+		"environments": {FieldSelector: "metadata.name"},
+	}
+	for resource, watch := range tests {
+		o := &controller.RoleOptions{
+			NoWatch: true,
+			Watches: map[string]controller.WatchOptions{resource: watch},
+		}
+		testhelpers.ConfigureTestOptionsWithResources(o, nil, nil)
+		err := o.Run(context.Background())
+		assert.Error(t, err, "watch options of %s should be invalid", resource)
+	}
+}
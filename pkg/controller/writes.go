@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"encoding/json"
+
+	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
+	"github.com/jenkins-x/jx-role-controller/pkg/metrics"
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// all changes the controller makes to Roles and RoleBindings go through these functions so they are recorded
+// consistently, in dry-run mode they are only added to the plan
+
+const (
+	kindRole        = "Role"
+	kindRoleBinding = "RoleBinding"
+	kindClusterRole = "ClusterRole"
+
+	operationCreate = "create"
+	operationUpdate = "update"
+	operationDelete = "delete"
+	operationApply  = "apply"
+)
+
+// validateRoleRef returns an error if the roleRef of an EnvironmentRoleBinding does not refer to a Role or ClusterRole
+func validateRoleRef(roleRef rbacv1.RoleRef) error {
+	if roleRef.APIGroup != rbacv1.GroupName {
+		return errors.Errorf("roleRef apiGroup %q is not %s", roleRef.APIGroup, rbacv1.GroupName)
+	}
+	if roleRef.Kind != kindRole && roleRef.Kind != kindClusterRole {
+		return errors.Errorf("roleRef kind %q is not %s or %s", roleRef.Kind, kindRole, kindClusterRole)
+	}
+	if roleRef.Name == "" {
+		return errors.New("roleRef has no name")
+	}
+	return nil
+}
+
+// newRole returns the Role the controller propagates from the team Role into an environment namespace
+func (o *RoleOptions) newRole(source *rbacv1.Role, name string, rules []rbacv1.PolicyRule) *rbacv1.Role {
+	labels, annotations := o.propagatedMetadata(kindRole, source, nil)
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       kindRole,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Rules: rules,
+	}
+}
+
+// newRoleBinding returns the RoleBinding the controller propagates from the EnvironmentRoleBinding into an
+// environment namespace. Owner references cannot cross namespaces so only a RoleBinding in the namespace of the
+// EnvironmentRoleBinding is owned by it
+func (o *RoleOptions) newRoleBinding(source *v1.EnvironmentRoleBinding, ns, name string) *rbacv1.RoleBinding {
+	var ownerReferences []metav1.OwnerReference
+	if ns == source.Namespace && source.UID != "" {
+		isController := true
+		ownerReferences = append(ownerReferences, metav1.OwnerReference{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       kindEnvironmentRoleBinding,
+			Name:       source.Name,
+			UID:        source.UID,
+			Controller: &isController,
+		})
+	}
+	labels, annotations := o.propagatedMetadata(kindEnvironmentRoleBinding, source, ownerReferences)
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       kindRoleBinding,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Labels:          labels,
+			Annotations:     annotations,
+			OwnerReferences: ownerReferences,
+		},
+		Subjects: source.Spec.Subjects,
+		RoleRef:  source.Spec.RoleRef,
+	}
+}
+
+func (o *RoleOptions) createRole(ns string, role *rbacv1.Role) error {
+	if o.DryRun {
+		o.plan.add(Change{operationCreate, kindRole, ns, role.Name, describeRules(role.Rules)})
+		return nil
+	}
+	_, err := o.KubeClient.RbacV1().Roles(ns).Create(role)
+	return recordWrite(kindRole, operationCreate, ns, err)
+}
+
+func (o *RoleOptions) updateRole(ns string, role *rbacv1.Role) error {
+	if o.DryRun {
+		o.plan.add(Change{operationUpdate, kindRole, ns, role.Name, describeRules(role.Rules)})
+		return nil
+	}
+	_, err := o.KubeClient.RbacV1().Roles(ns).Update(role)
+	return recordWrite(kindRole, operationUpdate, ns, err)
+}
+
+func (o *RoleOptions) deleteRole(ns, name string) error {
+	if o.DryRun {
+		o.plan.add(Change{Operation: operationDelete, Kind: kindRole, Namespace: ns, Name: name})
+		return nil
+	}
+	err := o.KubeClient.RbacV1().Roles(ns).Delete(name, nil)
+	return recordWrite(kindRole, operationDelete, ns, err)
+}
+
+func (o *RoleOptions) createRoleBinding(ns string, roleBinding *rbacv1.RoleBinding) error {
+	if o.DryRun {
+		o.plan.add(Change{operationCreate, kindRoleBinding, ns, roleBinding.Name, describeBinding(roleBinding.RoleRef, roleBinding.Subjects)})
+		return nil
+	}
+	_, err := o.KubeClient.RbacV1().RoleBindings(ns).Create(roleBinding)
+	return recordWrite(kindRoleBinding, operationCreate, ns, err)
+}
+
+func (o *RoleOptions) updateRoleBinding(ns string, roleBinding *rbacv1.RoleBinding) error {
+	if o.DryRun {
+		o.plan.add(Change{operationUpdate, kindRoleBinding, ns, roleBinding.Name, describeBinding(roleBinding.RoleRef, roleBinding.Subjects)})
+		return nil
+	}
+	_, err := o.KubeClient.RbacV1().RoleBindings(ns).Update(roleBinding)
+	return recordWrite(kindRoleBinding, operationUpdate, ns, err)
+}
+
+func (o *RoleOptions) deleteRoleBinding(ns, name string) error {
+	if o.DryRun {
+		o.plan.add(Change{Operation: operationDelete, Kind: kindRoleBinding, Namespace: ns, Name: name})
+		return nil
+	}
+	err := o.KubeClient.RbacV1().RoleBindings(ns).Delete(name, nil)
+	return recordWrite(kindRoleBinding, operationDelete, ns, err)
+}
+
+func (o *RoleOptions) createEnvironmentRoleBinding(binding *v1.EnvironmentRoleBinding) error {
+	if o.DryRun {
+		o.plan.add(Change{operationCreate, kindEnvironmentRoleBinding, binding.Namespace, binding.Name, describeBinding(binding.Spec.RoleRef, binding.Spec.Subjects)})
+		return nil
+	}
+	_, err := o.JxClient.JenkinsV1().EnvironmentRoleBindings(binding.Namespace).Create(binding)
+	return err
+}
+
+// applyRole applies the Role, returning true if that changed it. The server only bumps the resourceVersion when an
+// apply changes the object, so an unchanged Role is neither counted as a write nor reported in an event
+func (o *RoleOptions) applyRole(ns string, role *rbacv1.Role, resourceVersion string) (bool, error) {
+	applied := &rbacv1.Role{}
+	err := o.apply("roles", ns, role.Name, role, applied)
+	if err != nil {
+		return false, errors.Wrapf(err, "applying Role %s in namespace %s", role.Name, ns)
+	}
+	if resourceVersion != "" && applied.ResourceVersion == resourceVersion {
+		return false, nil
+	}
+	return true, recordWrite(kindRole, operationApply, ns, nil)
+}
+
+// applyRoleBinding applies the RoleBinding, returning true if that changed it
+func (o *RoleOptions) applyRoleBinding(ns string, roleBinding *rbacv1.RoleBinding, resourceVersion string) (bool, error) {
+	applied := &rbacv1.RoleBinding{}
+	err := o.apply("rolebindings", ns, roleBinding.Name, roleBinding, applied)
+	if err != nil {
+		return false, errors.Wrapf(err, "applying RoleBinding %s in namespace %s", roleBinding.Name, ns)
+	}
+	if resourceVersion != "" && applied.ResourceVersion == resourceVersion {
+		return false, nil
+	}
+	return true, recordWrite(kindRoleBinding, operationApply, ns, nil)
+}
+
+// apply sends the object as a server-side apply patch owned by the field manager, decoding the applied object into
+// the result. The client-go version we use has no PatchOptions so the request is built on the REST client
+func (o *RoleOptions) apply(resource, ns, name string, obj, result runtime.Object) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return errors.Wrap(err, "converting to unstructured")
+	}
+	// the server would otherwise see the empty timestamp as a field we want to manage
+	unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+	data, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "marshalling apply patch")
+	}
+	fieldManager := o.FieldManager
+	if fieldManager == "" {
+		fieldManager = defaultFieldManager
+	}
+	err = o.KubeClient.RbacV1().RESTClient().Patch(types.ApplyPatchType).
+		Namespace(ns).
+		Resource(resource).
+		Name(name).
+		Param("fieldManager", fieldManager).
+		Body(data).
+		Do().
+		Into(result)
+	if apierrors.IsConflict(err) {
+		return errors.Wrapf(err, "fields managed by %s are also managed by another field manager, remove them from the other manager or stop using server-side apply", fieldManager)
+	}
+	return err
+}
+
+// recordWrite records the write in the metrics if it succeeded
+func recordWrite(kind, operation, ns string, err error) error {
+	if err == nil {
+		metrics.RecordWrite(kind, operation, ns)
+	}
+	return err
+}
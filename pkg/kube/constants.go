@@ -15,4 +15,38 @@ const (
 
 	// LabelKind to indicate the kind of auth, such as Git or Issue
 	LabelKind = "jenkins.io/kind"
+
+	// FinalizerRoleController is added to EnvironmentRoleBindings so the RoleBindings propagated into the environment
+	// namespaces are removed before the EnvironmentRoleBinding is deleted
+	FinalizerRoleController = "jenkins.io/role-controller"
+
+	// AnnotationSyncStatus records on an EnvironmentRoleBinding whether its Role and RoleBinding are in sync in each
+	// environment namespace, as the CRD has no status subresource for it
+	AnnotationSyncStatus = "jenkins.io/role-controller-status"
+
+	// AnnotationAggregateClusterRoles is a comma separated list of ClusterRoles on an EnvironmentRole whose rules are
+	// added to the rules of the Role propagated into the environment namespaces
+	AnnotationAggregateClusterRoles = "jenkins.io/aggregate-cluster-roles"
+
+	// AnnotationEnvironmentSelector is a label selector on an EnvironmentRoleBinding, such as "tier=production", which
+	// the labels of an Environment have to match as well as the environment filters for the binding to apply to it
+	AnnotationEnvironmentSelector = "jenkins.io/environment-selector"
+
+	// AnnotationAdoptTeam on a Role or RoleBinding in an environment namespace lets the team named by its value take it
+	// over from the team which owns it
+	AnnotationAdoptTeam = "jenkins.io/adopt-team"
+
+	// AnnotationSourceNamespace is the namespace of the team Role or EnvironmentRoleBinding a Role or RoleBinding in an
+	// environment namespace was propagated from
+	AnnotationSourceNamespace = "jenkins.io/source-namespace"
+	// AnnotationSourceKind is the kind of the object a Role or RoleBinding was propagated from
+	AnnotationSourceKind = "jenkins.io/source-kind"
+	// AnnotationSourceName is the name of the object a Role or RoleBinding was propagated from
+	AnnotationSourceName = "jenkins.io/source-name"
+	// AnnotationSourceUID is the UID of the object a Role or RoleBinding was propagated from
+	AnnotationSourceUID = "jenkins.io/source-uid"
+
+	// AnnotationManagedMetadata records the labels, annotations and owner references the controller manages on a
+	// propagated Role or RoleBinding, so it can remove them when they are no longer propagated
+	AnnotationManagedMetadata = "jenkins.io/managed-metadata"
 )
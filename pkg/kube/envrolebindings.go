@@ -3,6 +3,8 @@ package kube
 import (
 	v1 "github.com/jenkins-x/jx-api/pkg/apis/jenkins.io/v1"
 	"github.com/jenkins-x/jx-role-controller/pkg/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // EnvironmentMatches returns true if the environment matches the given filter
@@ -29,3 +31,31 @@ func EnvironmentMatchesAny(env *v1.Environment, filters []v1.EnvironmentFilter)
 	}
 	return len(filters) == 0
 }
+
+// EnvironmentSelector returns the label selector of the AnnotationEnvironmentSelector annotation on the
+// EnvironmentRoleBinding or nil if it has none
+func EnvironmentSelector(binding *v1.EnvironmentRoleBinding) (labels.Selector, error) {
+	text, ok := binding.Annotations[AnnotationEnvironmentSelector]
+	if !ok {
+		return nil, nil
+	}
+	selector, err := labels.Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s annotation %q", AnnotationEnvironmentSelector, text)
+	}
+	return selector, nil
+}
+
+// BindingMatchesEnvironment returns true if the environment matches the environment filters of the
+// EnvironmentRoleBinding and the labels of the environment match its environment selector annotation.
+// A binding with an invalid selector matches no environments
+func BindingMatchesEnvironment(env *v1.Environment, binding *v1.EnvironmentRoleBinding) bool {
+	if !EnvironmentMatchesAny(env, binding.Spec.Environments) {
+		return false
+	}
+	selector, err := EnvironmentSelector(binding)
+	if err != nil {
+		return false
+	}
+	return selector == nil || selector.Matches(labels.Set(env.Labels))
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/pkg/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 func NewClientAndConfig() (kubernetes.Interface, *rest.Config, error) {
@@ -23,3 +24,33 @@ func NewClientAndConfig() (kubernetes.Interface, *rest.Config, error) {
 	}
 	return client, config, nil
 }
+
+// NewClientAndConfigForContext creates the client and config for the context in the kubeconfig file, returning the
+// namespace of the context too. If neither is specified it falls back to NewClientAndConfig and the current namespace
+func NewClientAndConfigForContext(kubeconfig, kubeContext string) (kubernetes.Interface, *rest.Config, string, error) {
+	if kubeconfig == "" && kubeContext == "" {
+		namespace, err := kubeclient.CurrentNamespace()
+		if err != nil {
+			return nil, nil, "", errors.WithStack(err)
+		}
+		client, config, err := NewClientAndConfig()
+		return client, config, namespace, err
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, nil, "", errors.Wrapf(err, "loading kubeconfig %s context %s", kubeconfig, kubeContext)
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, nil, "", errors.Wrapf(err, "finding the namespace of kubeconfig %s context %s", kubeconfig, kubeContext)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "building kubernetes clientset")
+	}
+	return client, config, namespace, nil
+}
@@ -0,0 +1,20 @@
+package kube
+
+// IsOwnedByTeam returns true if the labels mark a resource as created by Jenkins X on behalf of the given team
+func IsOwnedByTeam(labels map[string]string, team string) bool {
+	return labels[LabelCreatedBy] == ValueCreatedByJX && labels[LabelTeam] == team
+}
+
+// OwningTeam returns the team a resource was created by Jenkins X on behalf of, or blank if it was not created by
+// Jenkins X or by a version which did not record the team
+func OwningTeam(labels map[string]string) string {
+	if labels[LabelCreatedBy] != ValueCreatedByJX {
+		return ""
+	}
+	return labels[LabelTeam]
+}
+
+// IsEnvironmentRole returns true if the labels mark a team Role as an EnvironmentRole to propagate into environments
+func IsEnvironmentRole(labels map[string]string) bool {
+	return labels[LabelKind] == ValueKindEnvironmentRole
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "jx_role_controller"
+
+var (
+	// Reconciles counts the reconciles of each kind of watched resource
+	Reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciles_total",
+		Help:      "Number of reconciles per resource kind.",
+	}, []string{"kind"})
+
+	// ReconcileErrors counts the reconciles of each kind of watched resource which failed
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed reconciles per resource kind.",
+	}, []string{"kind"})
+
+	// ReconcileDuration observes how long reconciling each kind of watched resource takes
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time taken to reconcile per resource kind.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	// Writes counts the creates, updates and deletes of Roles and RoleBindings in each target namespace
+	Writes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "writes_total",
+		Help:      "Number of Role and RoleBinding creates, updates and deletes per target namespace.",
+	}, []string{"kind", "operation", "namespace"})
+
+	// QueueDepth is the number of resources of each team waiting to be reconciled
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of resources waiting to be reconciled per team.",
+	}, []string{"team"})
+
+	// OwnershipConflicts counts the Roles and RoleBindings which were not modified as they are owned by another team or
+	// were not created by the controller
+	OwnershipConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ownership_conflicts_total",
+		Help:      "Number of Roles and RoleBindings not modified as they are owned by another team or not created by the controller, per target namespace.",
+	}, []string{"kind", "namespace"})
+
+	// LastFullSync is the time each team was last fully reconciled: by a sync, by reconciling everything the watches
+	// listed at startup or by a prune pass comparing everything the team owns with its sources
+	LastFullSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_full_sync_timestamp_seconds",
+		Help:      "Unix time of the last successful full sync, startup reconcile or prune pass per team.",
+	}, []string{"team"})
+)
+
+func init() {
+	prometheus.MustRegister(Reconciles, ReconcileErrors, ReconcileDuration, Writes, QueueDepth, OwnershipConflicts, LastFullSync)
+}
+
+// ObserveReconcile records a reconcile of the given kind which started at the given time
+func ObserveReconcile(kind string, start time.Time, err error) {
+	Reconciles.WithLabelValues(kind).Inc()
+	ReconcileDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ReconcileErrors.WithLabelValues(kind).Inc()
+	}
+}
+
+// RecordWrite records a create, update or delete of a Role or RoleBinding in the namespace
+func RecordWrite(kind, operation, ns string) {
+	Writes.WithLabelValues(kind, operation, ns).Inc()
+}
+
+// RecordOwnershipConflict records that a Role or RoleBinding in the namespace was not modified as another team owns it
+// or it was not created by the controller
+func RecordOwnershipConflict(kind, ns string) {
+	OwnershipConflicts.WithLabelValues(kind, ns).Inc()
+}
+
+// RecordFullSync records a successful full sync of the team at the current time
+func RecordFullSync(team string) {
+	LastFullSync.WithLabelValues(team).SetToCurrentTime()
+}
+
+// SetQueueDepth records the number of resources of the team waiting to be reconciled
+func SetQueueDepth(team string, depth int) {
+	QueueDepth.WithLabelValues(team).Set(float64(depth))
+}
+
+// ForgetTeam removes the metrics of a team which is no longer processed
+func ForgetTeam(team string) {
+	QueueDepth.DeleteLabelValues(team)
+	LastFullSync.DeleteLabelValues(team)
+}
+
+// Handler returns the HTTP handler exposing the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,26 @@
+package signals
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jenkins-x/jx-logging/pkg/log"
+)
+
+// NewContext returns a context which is cancelled on SIGINT or SIGTERM.
+// A second signal exits the process immediately
+func NewContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Logger().Infof("received %s, shutting down", sig)
+		cancel()
+		<-signals
+		os.Exit(1)
+	}()
+	return ctx
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RegexpPatternPrefix marks a pattern as a regular expression rather than a glob
+const RegexpPatternPrefix = "re:"
+
+// patternCache holds the compiled regular expression of each pattern, or the error compiling it
+var patternCache sync.Map
+
+type compiledPattern struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// CompilePattern compiles the pattern into a regular expression which matches the whole text.
+//
+// Patterns are globs where `*` matches any characters, `?` matches a single character, `[abc]`, `[a-z]` and
+// `[!abc]` match a character class and `\` escapes the next character. Patterns starting with `re:` are
+// regular expressions instead, which are anchored so they have to match the whole text.
+// Compiled patterns are cached
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if value, ok := patternCache.Load(pattern); ok {
+		compiled := value.(compiledPattern)
+		return compiled.re, compiled.err
+	}
+	var expression string
+	var err error
+	if strings.HasPrefix(pattern, RegexpPatternPrefix) {
+		expression = "^(?:" + strings.TrimPrefix(pattern, RegexpPatternPrefix) + ")$"
+	} else {
+		expression, err = globToRegexp(pattern)
+	}
+	var re *regexp.Regexp
+	if err == nil {
+		re, err = regexp.Compile(expression)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+	patternCache.Store(pattern, compiledPattern{re: re, err: err})
+	return re, err
+}
+
+// globToRegexp converts the glob into an anchored regular expression
+func globToRegexp(glob string) (string, error) {
+	var buffer strings.Builder
+	buffer.WriteString("^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			buffer.WriteString(".*")
+		case '?':
+			buffer.WriteString(".")
+		case '\\':
+			if i+1 == len(runes) {
+				return "", errors.New("trailing escape character")
+			}
+			i++
+			buffer.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			// a ] straight after the opening bracket is part of the class
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return "", errors.New("unterminated character class")
+			}
+			class := runes[i+1 : end]
+			buffer.WriteString("[")
+			if class[0] == '!' || class[0] == '^' {
+				buffer.WriteString("^")
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '\\' || r == '[' || r == ']' {
+					buffer.WriteString("\\")
+				}
+				buffer.WriteRune(r)
+			}
+			buffer.WriteString("]")
+			i = end
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	buffer.WriteString("$")
+	return buffer.String(), nil
+}
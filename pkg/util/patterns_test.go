@@ -0,0 +1,132 @@
+// +build unit
+
+package util
+
+import (
+	"testing"
+)
+
+func TestStringMatchesPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		matches bool
+	}{
+		// literals
+		{"staging", "staging", true},
+		{"staging", "staging2", false},
+		{"staging", "my-staging", false},
+		{"", "", true},
+		{"", "staging", false},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{"a+b", "a+b", true},
+		{"(prod)", "(prod)", true},
+
+		// wildcards
+		{"*", "", true},
+		{"*", "anything", true},
+		{"preview-*", "preview-pr-1", true},
+		{"preview-*", "preview-", true},
+		{"preview-*", "preview", false},
+		{"*-staging", "team-staging", true},
+		{"*-staging", "team-staging-old", false},
+		{"team-*-prod", "team-a-prod", true},
+		{"team-*-prod", "team--prod", true},
+		{"team-*-prod", "team-a-staging", false},
+		{"*stag*", "team-staging-old", true},
+		{"**", "x", true},
+
+		// single characters
+		{"team-?-prod", "team-a-prod", true},
+		{"team-?-prod", "team-ab-prod", false},
+		{"team-?-prod", "team--prod", false},
+		{"??", "ab", true},
+		{"??", "a", false},
+
+		// character classes
+		{"preview-pr-[0-9]*", "preview-pr-42", true},
+		{"preview-pr-[0-9]*", "preview-pr-x42", false},
+		{"env-[abc]", "env-b", true},
+		{"env-[abc]", "env-d", false},
+		{"env-[!abc]", "env-d", true},
+		{"env-[!abc]", "env-a", false},
+		{"env-[^abc]", "env-d", true},
+		{"env-[a-c-]", "env--", true},
+		{"env-[]]", "env-]", true},
+		{"env-[!]]", "env-a", true},
+		{"env-[!]]", "env-]", false},
+
+		// escapes
+		{`star-\*`, "star-*", true},
+		{`star-\*`, "star-x", false},
+		{`what\?`, "what?", true},
+		{`what\?`, "whatx", false},
+		{`\[x]`, "[x]", true},
+
+		// regular expressions
+		{"re:prod(uction)?", "prod", true},
+		{"re:prod(uction)?", "production", true},
+		{"re:prod(uction)?", "preprod", false},
+		{"re:prod(uction)?", "production-eu", false},
+		{"re:preview-pr-[0-9]+", "preview-pr-123", true},
+		{"re:preview-pr-[0-9]+", "preview-pr-", false},
+		{"re:a|b", "a", true},
+		{"re:a|b", "ab", false},
+		{"re:.*", "", true},
+
+		// invalid patterns only match themselves
+		{"env-[abc", "env-[abc", true},
+		{"env-[abc", "env-a", false},
+		{`trailing\`, `trailing\`, true},
+		{"re:(", "re:(", true},
+		{"re:(", "(", false},
+	}
+	for _, test := range tests {
+		actual := StringMatchesPattern(test.text, test.pattern)
+		if actual != test.matches {
+			t.Errorf("StringMatchesPattern(%q, %q) = %v, expected %v", test.text, test.pattern, actual, test.matches)
+		}
+		// the second call uses the cached pattern
+		actual = StringMatchesPattern(test.text, test.pattern)
+		if actual != test.matches {
+			t.Errorf("cached StringMatchesPattern(%q, %q) = %v, expected %v", test.text, test.pattern, actual, test.matches)
+		}
+	}
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	for _, pattern := range []string{"[abc", "[!", `abc\`, "re:(", "re:a{2,1}"} {
+		if _, err := CompilePattern(pattern); err == nil {
+			t.Errorf("expected an error compiling pattern %q", pattern)
+		}
+	}
+	for _, pattern := range []string{"", "*", "a?[b-d]\\*", "re:^abc$"} {
+		if _, err := CompilePattern(pattern); err != nil {
+			t.Errorf("unexpected error compiling pattern %q: %s", pattern, err)
+		}
+	}
+}
+
+func TestStringMatchesAny(t *testing.T) {
+	tests := []struct {
+		text     string
+		includes []string
+		excludes []string
+		matches  bool
+	}{
+		{"staging", nil, nil, true},
+		{"staging", []string{"production"}, nil, false},
+		{"team-staging", []string{"*-staging"}, nil, true},
+		{"preview-pr-1", []string{"preview-pr-[0-9]*"}, []string{"re:preview-pr-1"}, false},
+		{"preview-pr-12", []string{"preview-pr-[0-9]*"}, []string{"re:preview-pr-1"}, true},
+		{"production", nil, []string{"prod*"}, false},
+		{"team-a-prod", []string{"staging", "team-?-prod"}, nil, true},
+	}
+	for _, test := range tests {
+		actual := StringMatchesAny(test.text, test.includes, test.excludes)
+		if actual != test.matches {
+			t.Errorf("StringMatchesAny(%q, %v, %v) = %v, expected %v", test.text, test.includes, test.excludes, actual, test.matches)
+		}
+	}
+}
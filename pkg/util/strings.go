@@ -1,7 +1,5 @@
 package util
 
-import "strings"
-
 // StringMatchesAny returns true if the given text matches the includes/excludes lists
 func StringMatchesAny(text string, includes, excludes []string) bool {
 	for _, x := range excludes {
@@ -20,16 +18,17 @@ func StringMatchesAny(text string, includes, excludes []string) bool {
 	return false
 }
 
-// StringMatchesPattern returns true if the given text matches the includes/excludes lists
+// StringMatchesPattern returns true if the given text matches the glob or `re:` regular expression pattern, see
+// CompilePattern. Invalid patterns only match text which is equal to them
 func StringMatchesPattern(text, pattern string) bool {
 	if pattern == "*" {
 		return true
 	}
-	if strings.HasSuffix(pattern, "*") {
-		prefix := strings.TrimSuffix(pattern, "*")
-		return strings.HasPrefix(text, prefix)
+	re, err := CompilePattern(pattern)
+	if err != nil {
+		return text == pattern
 	}
-	return text == pattern
+	return re.MatchString(text)
 }
 
 func EnvVarBoolean(value string) bool {
@@ -0,0 +1,11 @@
+package version
+
+// these are set at build time with -ldflags "-X github.com/jenkins-x/jx-role-controller/pkg/version.Version=..."
+var (
+	// Version is the released version of the binary
+	Version = "dev"
+	// Commit is the git commit the binary was built from
+	Commit = "unknown"
+	// BuildDate is when the binary was built
+	BuildDate = "unknown"
+)